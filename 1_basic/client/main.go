@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/1_basic/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
 	"log"
 	"os"
@@ -13,8 +16,19 @@ const (
 	address = "127.0.0.1:20051"
 )
 
+var (
+	tlsConfig     = tlsutil.ClientFlags()
+	serverAddress = endpoint.AddressFlag(address)
+)
+
 func main() {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	flag.Parse()
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	conn, err := endpoint.Dial(*serverAddress, transport)
 	if err != nil {
 		log.Fatalf("did not connect :%v", err)
 	}
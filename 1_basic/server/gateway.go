@@ -0,0 +1,41 @@
+package main
+
+import (
+	pb "github.com/kekeee-shine/grpc_training/1_basic/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/gateway"
+	"google.golang.org/grpc"
+	"net/http"
+)
+
+// gatewayRoutes maps the HTTP/JSON endpoints onto ProductInfo:
+//
+//	POST /v1/products       addProduct, with the product as body
+//	GET  /v1/products/{id}  getProduct
+func gatewayRoutes(conn *grpc.ClientConn) http.Handler {
+	client := pb.NewProductInfoClient(conn)
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/products", func(w http.ResponseWriter, r *http.Request) {
+		product := &pb.Product{}
+		if err := gateway.ReadMessage(r, product); err != nil {
+			gateway.WriteError(w, err)
+			return
+		}
+		id, err := client.AddProduct(gateway.Context(r), product)
+		if err != nil {
+			gateway.WriteError(w, err)
+			return
+		}
+		gateway.WriteMessage(w, id)
+	})
+
+	mux.HandleFunc("GET /v1/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		product, err := client.GetProduct(gateway.Context(r), &pb.ProductID{Value: r.PathValue("id")})
+		if err != nil {
+			gateway.WriteError(w, err)
+			return
+		}
+		gateway.WriteMessage(w, product)
+	})
+	return mux
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/1_basic/proto"
 	svc "github.com/kekeee-shine/grpc_training/1_basic/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/audit"
+	"github.com/kekeee-shine/grpc_training/pkg/cache"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/gateway"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"github.com/kekeee-shine/grpc_training/pkg/shutdown"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"log"
-	"net"
+	"time"
 )
 
 const (
@@ -13,20 +23,75 @@ const (
 	port    = ":20051"
 )
 
+var (
+	tlsConfig      = tlsutil.ServerFlags()
+	listenAddress  = endpoint.ListenFlag(port)
+	shutdownConfig = shutdown.Flags()
+	gatewayConfig  = gateway.Flags()
+	auditFile      = flag.String("audit_log", "audit.log", "hash-chained log of every mutating call")
+)
+
 func main() {
+	flag.Parse()
+
+	creds, err := tlsConfig.ServerOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
 
-	// listen the tcp port
-	lis, err := net.Listen("tcp", port)
+	// listen on tcp, a unix socket or in process
+	lis, err := endpoint.Listen(*listenAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	auditLog, err := audit.Open(*auditFile)
+	if err != nil {
+		log.Fatalf("failed to open audit log: %v", err)
+	}
+	auditor := audit.NewAuditor(auditLog, audit.Config{
+		"/basic.ProductInfo/addProduct": func(req interface{}) string {
+			return "product/" + req.(*pb.Product).Name
+		},
+	})
+
+	// GetProduct is a pure read, and a product never changes once added: AddProduct always
+	// creates a new ID, so there is nothing for it to invalidate
+	responseCache := cache.NewResponseCache(cache.Config{
+		TTLs: map[string]time.Duration{
+			"/basic.ProductInfo/getProduct": time.Minute * 5,
+		},
+	})
+	go responseCache.Report(time.Minute, nil)
+
+	s := grpc.NewServer(creds, grpc.ChainUnaryInterceptor(auditor.UnaryServerInterceptor(), responseCache.UnaryServerInterceptor()))
 	pb.RegisterProductInfoServer(s, svc.NewServer())
-	log.Printf("Starting gRPC listener on port " + port)
-	if err := s.Serve(lis); err != nil {
+
+	// health reports SERVING for every service until the server shuts down
+	hs := healthcheck.Register(s)
+	// reflection lets cmd/grpcall and other tools call the services without their .proto files
+	reflection.Register(s)
+
+	// HTTP/1.1 connections on the port go to the gateway and gRPC-Web, HTTP/2 ones to gRPC
+	var gw *gateway.Server
+	if gatewayConfig.Enabled && tlsConfig.CertFile != "" {
+		log.Printf("the HTTP gateway needs a server without TLS, serving gRPC only")
+	} else if gatewayConfig.Enabled {
+		lis, gw, err = gatewayConfig.Serve(s, lis, gatewayRoutes)
+		if err != nil {
+			log.Fatalf("failed to start the gateway: %v", err)
+		}
+	}
+
+	log.Printf("Starting gRPC listener on %s", *listenAddress)
+	if err := shutdownConfig.Serve(s, lis, hs); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	if gw != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownConfig.DrainTimeout)
+		defer cancel()
+		if err := gw.Shutdown(ctx); err != nil {
+			log.Printf("failed to drain the gateway: %v", err)
+		}
+	}
 }
-
-
@@ -0,0 +1,266 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of one target and method.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through and counts the failures.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every call right away until OpenTimeout has passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe calls through: one success closes the breaker,
+	// one failure opens it again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig tunes the circuit breakers. Only the codes in FailureCodes count as
+// failures, Unavailable and DeadlineExceeded if not set: the others are answers of a
+// healthy server.
+type BreakerConfig struct {
+	// FailureThreshold is the number of failures in a row that opens the breaker.
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	// HalfOpenProbes is the number of calls let through at the same time while half-open.
+	HalfOpenProbes int
+	FailureCodes   []codes.Code
+	// OnStateChange, if set, is called on every transition, e.g. to update a gauge.
+	OnStateChange func(key BreakerKey, from, to BreakerState)
+}
+
+// BreakerKey names the breaker of one method of one target.
+type BreakerKey struct {
+	Target string
+	Method string
+}
+
+// BreakerStats are the state and counters of one breaker.
+type BreakerStats struct {
+	State    BreakerState
+	Failures int
+	Trips    uint64
+	Rejected uint64
+}
+
+type breaker struct {
+	BreakerStats
+	openedAt time.Time
+	probes   int
+}
+
+// CircuitBreaker stops sending calls to a target and method that keeps failing, and
+// fails them right away instead of letting every caller wait for its deadline.
+type CircuitBreaker struct {
+	config BreakerConfig
+	// now is time.Now, tests move it forward through the cool-down
+	now func() time.Time
+
+	mu       sync.Mutex
+	breakers map[BreakerKey]*breaker
+}
+
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = time.Second * 10
+	}
+	if config.HalfOpenProbes < 1 {
+		config.HalfOpenProbes = 1
+	}
+	if len(config.FailureCodes) == 0 {
+		config.FailureCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded}
+	}
+	return &CircuitBreaker{config: config, now: time.Now, breakers: make(map[BreakerKey]*breaker)}
+}
+
+// Stats returns a copy of the state and counters of every breaker.
+func (cb *CircuitBreaker) Stats() map[BreakerKey]BreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	stats := make(map[BreakerKey]BreakerStats, len(cb.breakers))
+	for key, b := range cb.breakers {
+		stats[key] = b.BreakerStats
+	}
+	return stats
+}
+
+// State is the current state of the breaker of target and method.
+func (cb *CircuitBreaker) State(target, method string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if b, ok := cb.breakers[BreakerKey{Target: target, Method: method}]; ok {
+		return b.State
+	}
+	return BreakerClosed
+}
+
+// transition must be called with cb.mu held.
+func (cb *CircuitBreaker) transition(key BreakerKey, b *breaker, to BreakerState) {
+	from := b.State
+	if from == to {
+		return
+	}
+	b.State = to
+	switch to {
+	case BreakerOpen:
+		b.openedAt = cb.now()
+		b.Trips++
+	case BreakerClosed:
+		b.Failures = 0
+	}
+	log.Printf("[Breaker Interceptor] %s %s: %v -> %v", key.Target, key.Method, from, to)
+	if cb.config.OnStateChange != nil {
+		cb.config.OnStateChange(key, from, to)
+	}
+}
+
+// allow reports whether a call may go out now, and whether it is a half-open probe.
+func (cb *CircuitBreaker) allow(key BreakerKey) (bool, bool, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	b, ok := cb.breakers[key]
+	if !ok {
+		b = &breaker{}
+		cb.breakers[key] = b
+	}
+
+	if b.State == BreakerOpen {
+		wait := cb.config.OpenTimeout - cb.now().Sub(b.openedAt)
+		if wait > 0 {
+			b.Rejected++
+			return false, false, status.Errorf(codes.Unavailable,
+				"circuit breaker open for %s on %s after %d failures, retry in %v", key.Method, key.Target, b.Failures, wait.Round(time.Millisecond))
+		}
+		cb.transition(key, b, BreakerHalfOpen)
+	}
+	if b.State == BreakerHalfOpen {
+		if b.probes >= cb.config.HalfOpenProbes && cb.now().Sub(b.openedAt) > 2*cb.config.OpenTimeout {
+			// the probes never reported back, their streams were abandoned
+			b.probes = 0
+		}
+		if b.probes >= cb.config.HalfOpenProbes {
+			b.Rejected++
+			return false, false, status.Errorf(codes.Unavailable,
+				"circuit breaker half-open for %s on %s, waiting for the probe calls", key.Method, key.Target)
+		}
+		b.probes++
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+func (cb *CircuitBreaker) failure(err error) bool {
+	code := status.Code(err)
+	if err == context.DeadlineExceeded {
+		code = codes.DeadlineExceeded
+	}
+	for _, c := range cb.config.FailureCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (cb *CircuitBreaker) done(key BreakerKey, probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	b := cb.breakers[key]
+	if probe && b.probes > 0 {
+		b.probes--
+	}
+
+	if !cb.failure(err) {
+		if b.State == BreakerHalfOpen && probe {
+			cb.transition(key, b, BreakerClosed)
+		}
+		b.Failures = 0
+		return
+	}
+	b.Failures++
+	// a call sent before the breaker opened may still come back, it changes nothing
+	if b.State == BreakerHalfOpen && probe || b.State == BreakerClosed && b.Failures >= cb.config.FailureThreshold {
+		cb.transition(key, b, BreakerOpen)
+	}
+}
+
+// UnaryClientInterceptor Client :: Unary Interceptor
+// fails calls to an open circuit right away and counts the failures of the others
+func (cb *CircuitBreaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		key := BreakerKey{Target: cc.Target(), Method: method}
+		ok, probe, err := cb.allow(key)
+		if !ok {
+			return err
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		cb.done(key, probe, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor Client :: Stream Interceptor
+// fails streams to an open circuit right away. A stream counts as failed when opening it
+// or receiving on it fails with one of the failure codes, and as a success when it ends well.
+func (cb *CircuitBreaker) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		key := BreakerKey{Target: cc.Target(), Method: method}
+		ok, probe, err := cb.allow(key)
+		if !ok {
+			return nil, err
+		}
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cb.done(key, probe, err)
+			return nil, err
+		}
+		return &breakerStream{ClientStream: cs, serverStreams: desc.ServerStreams, report: func(err error) { cb.done(key, probe, err) }}, nil
+	}
+}
+
+// breakerStream reports the outcome of the stream once, on its first error or its end.
+// A stream the caller abandons is never reported, and holds its half-open probe slot
+// for one more OpenTimeout.
+type breakerStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	report        func(err error)
+	once          sync.Once
+}
+
+func (s *breakerStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.once.Do(func() { s.report(nil) })
+	case err != nil:
+		s.once.Do(func() { s.report(err) })
+	case !s.serverStreams:
+		s.once.Do(func() { s.report(nil) })
+	}
+	return err
+}
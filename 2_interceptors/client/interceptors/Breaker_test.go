@@ -0,0 +1,139 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// pending leaves the call that was let through running, it reports nothing yet.
+var pending = errors.New("still running")
+
+// breakerStep is one call: the clock moves by advance first, then the call asks the
+// breaker and, if let through, reports result.
+type breakerStep struct {
+	advance time.Duration
+	result  error
+	allowed bool
+	state   BreakerState
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const cooldown = time.Second * 10
+	var (
+		unavailable = status.Error(codes.Unavailable, "down")
+		deadline    = status.Error(codes.DeadlineExceeded, "slow")
+		notFound    = status.Error(codes.NotFound, "no such order")
+		internal    = status.Error(codes.Internal, "bug")
+	)
+	tests := []struct {
+		name   string
+		config BreakerConfig
+		steps  []breakerStep
+	}{
+		{"opens after the threshold of failures in a row", BreakerConfig{}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, deadline, true, BreakerClosed},
+			{0, context.DeadlineExceeded, true, BreakerOpen},
+			{0, nil, false, BreakerOpen},
+		}},
+		{"a success resets the count", BreakerConfig{}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, nil, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerOpen},
+		}},
+		{"answers of a healthy server are no failures", BreakerConfig{}, []breakerStep{
+			{0, notFound, true, BreakerClosed},
+			{0, internal, true, BreakerClosed},
+			{0, status.Error(codes.InvalidArgument, "bad id"), true, BreakerClosed},
+			{0, notFound, true, BreakerClosed},
+		}},
+		{"configured failure codes replace the defaults", BreakerConfig{FailureCodes: []codes.Code{codes.Internal}}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, internal, true, BreakerClosed},
+			{0, internal, true, BreakerClosed},
+			{0, internal, true, BreakerOpen},
+		}},
+		{"rejects until the cool-down is over, then a probe closes it", BreakerConfig{}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerOpen},
+			{cooldown - time.Millisecond, nil, false, BreakerOpen},
+			{time.Millisecond, nil, true, BreakerClosed},
+			{0, nil, true, BreakerClosed},
+		}},
+		{"a failed probe opens it for another cool-down", BreakerConfig{}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerOpen},
+			{cooldown, unavailable, true, BreakerOpen},
+			{cooldown - time.Millisecond, nil, false, BreakerOpen},
+			{time.Millisecond, nil, true, BreakerClosed},
+		}},
+		{"one probe at a time", BreakerConfig{}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerOpen},
+			{cooldown, pending, true, BreakerHalfOpen},
+			{0, nil, false, BreakerHalfOpen},
+			{time.Second, nil, false, BreakerHalfOpen},
+		}},
+		{"a probe that never reports back frees its slot", BreakerConfig{}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerOpen},
+			{cooldown, pending, true, BreakerHalfOpen},
+			{cooldown, nil, false, BreakerHalfOpen},
+			{time.Millisecond, nil, true, BreakerClosed},
+		}},
+		{"more probes when configured", BreakerConfig{HalfOpenProbes: 2}, []breakerStep{
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerClosed},
+			{0, unavailable, true, BreakerOpen},
+			{cooldown, pending, true, BreakerHalfOpen},
+			{0, pending, true, BreakerHalfOpen},
+			{0, nil, false, BreakerHalfOpen},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.FailureThreshold, config.OpenTimeout = 3, cooldown
+			cb := NewCircuitBreaker(config)
+			now := time.Now()
+			cb.now = func() time.Time { return now }
+			key := BreakerKey{Target: "bufnet", Method: "/proto.OrderManagement/getOrder"}
+
+			for i, step := range tt.steps {
+				now = now.Add(step.advance)
+				allowed, probe, err := cb.allow(key)
+				if allowed != step.allowed {
+					t.Fatalf("step %d: allowed %v (%v), want %v", i, allowed, err, step.allowed)
+				}
+				if !allowed && status.Code(err) != codes.Unavailable {
+					t.Fatalf("step %d: rejected with %v, want Unavailable", i, err)
+				}
+				if allowed && step.result != pending {
+					cb.done(key, probe, step.result)
+				}
+				if state := cb.State(key.Target, key.Method); state != step.state {
+					t.Fatalf("step %d: state %v, want %v", i, state, step.state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package interceptors
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"sync"
+	"time"
+)
+
+// HedgeConfig decides which calls are hedged and how. Only idempotent reads may be listed
+// in Methods: every copy of a call runs on the server.
+type HedgeConfig struct {
+	Methods map[string]bool
+	// Delay is how long an attempt may take before the next copy is sent.
+	Delay time.Duration
+	// MaxHedges is the number of copies sent on top of the first attempt, 1 if not set.
+	MaxHedges int
+	// Backends receive the copies in turn. Without backends the copies go to the
+	// connection of the call itself, which may still pick another backend.
+	Backends []*grpc.ClientConn
+	// Rate and Burst cap the copies per second over all calls, so that a slow server
+	// is not sent twice the load. A zero Rate turns hedging off.
+	Rate  float64
+	Burst int
+}
+
+// HedgeStats count the hedged calls.
+type HedgeStats struct {
+	Calls     uint64
+	Hedges    uint64
+	HedgeWins uint64
+	Throttled uint64
+}
+
+// Hedger sends a second copy of a slow read and takes the first successful response.
+type Hedger struct {
+	config  HedgeConfig
+	limiter *rate.Limiter
+
+	mu    sync.Mutex
+	next  int
+	stats HedgeStats
+}
+
+func NewHedger(config HedgeConfig) *Hedger {
+	if config.MaxHedges < 1 {
+		config.MaxHedges = 1
+	}
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	return &Hedger{config: config, limiter: rate.NewLimiter(rate.Limit(config.Rate), config.Burst)}
+}
+
+func (h *Hedger) Stats() HedgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// backend picks the connection of the next copy, nil for the connection of the call.
+func (h *Hedger) backend() *grpc.ClientConn {
+	if len(h.config.Backends) == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	backend := h.config.Backends[h.next%len(h.config.Backends)]
+	h.next++
+	return backend
+}
+
+func (h *Hedger) count(f func(s *HedgeStats)) {
+	h.mu.Lock()
+	f(&h.stats)
+	h.mu.Unlock()
+}
+
+// retriable is a failure of one backend that another one may not have: the next copy is
+// sent right away instead of after the delay. Any other error is the answer to the call.
+func retriable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+type attempt struct {
+	reply   proto.Message
+	err     error
+	hedged  bool
+	results *callResults
+}
+
+// callResults stand in for the options of a call that collect results, like
+// grpc.Header, so that every copy fills its own and only the answer's reach the caller.
+type callResults struct {
+	header  metadata.MD
+	trailer metadata.MD
+	peer    peer.Peer
+}
+
+func (r *callResults) options(opts []grpc.CallOption) []grpc.CallOption {
+	own := make([]grpc.CallOption, 0, len(opts))
+	for _, opt := range opts {
+		switch opt.(type) {
+		case grpc.HeaderCallOption:
+			own = append(own, grpc.Header(&r.header))
+		case grpc.TrailerCallOption:
+			own = append(own, grpc.Trailer(&r.trailer))
+		case grpc.PeerCallOption:
+			own = append(own, grpc.Peer(&r.peer))
+		default:
+			own = append(own, opt)
+		}
+	}
+	return own
+}
+
+// deliver copies the results into the options of the call.
+func (r *callResults) deliver(opts []grpc.CallOption) {
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = r.header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = r.trailer
+		case grpc.PeerCallOption:
+			*o.PeerAddr = r.peer
+		}
+	}
+}
+
+// UnaryClientInterceptor Client :: Unary Interceptor
+// sends another copy of a call that has not answered within the delay, returns the first
+// successful response and cancels the other copies. Call options that collect results,
+// like grpc.Header, get those of the copy that answered.
+func (h *Hedger) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		out, ok := reply.(proto.Message)
+		if !h.config.Methods[method] || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		h.count(func(s *HedgeStats) { s.Calls++ })
+
+		ctx, cancel := context.WithCancel(ctx)
+		// the copies still running when the call returns are cancelled
+		defer cancel()
+		results := make(chan attempt, h.config.MaxHedges+1)
+		send := func(conn *grpc.ClientConn, hedged bool) {
+			r := out.ProtoReflect().New().Interface()
+			own := &callResults{}
+			copyOpts := own.options(opts)
+			go func() {
+				var err error
+				if conn != nil {
+					// another backend: its own interceptors apply, ours already did
+					err = conn.Invoke(ctx, method, req, r, copyOpts...)
+				} else {
+					// the rest of this connection's chain, without hedging the copy again
+					err = invoker(ctx, method, req, r, cc, copyOpts...)
+				}
+				results <- attempt{reply: r, err: err, hedged: hedged, results: own}
+			}()
+		}
+
+		send(nil, false)
+		running, hedges := 1, 0
+		timer := time.NewTimer(h.config.Delay)
+		defer timer.Stop()
+		var lastErr error
+		var last *callResults
+		for {
+			hedge := false
+			select {
+			case a := <-results:
+				running--
+				if a.err == nil {
+					a.results.deliver(opts)
+					if a.hedged {
+						h.count(func(s *HedgeStats) { s.HedgeWins++ })
+					}
+					proto.Reset(out)
+					proto.Merge(out, a.reply)
+					return nil
+				}
+				if !retriable(a.err) {
+					a.results.deliver(opts)
+					return a.err
+				}
+				lastErr, last = a.err, a.results
+				hedge = true
+			case <-timer.C:
+				hedge = true
+			}
+
+			if hedge && hedges < h.config.MaxHedges {
+				if h.limiter.Allow() {
+					hedges++
+					h.count(func(s *HedgeStats) { s.Hedges++ })
+					log.Printf("[Hedging Interceptor] %s: sending copy %d", method, hedges)
+					send(h.backend(), true)
+					running++
+					timer.Reset(h.config.Delay)
+				} else {
+					h.count(func(s *HedgeStats) { s.Throttled++ })
+					// out of budget for now, the running copies have to do
+					hedges = h.config.MaxHedges
+				}
+			}
+			if running == 0 {
+				last.deliver(opts)
+				return lastErr
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowFirstHealth answers its first call late; every answer carries its number in the header.
+type slowFirstHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+	calls int32
+}
+
+func (s *slowFirstHealth) Check(ctx context.Context, _ *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	grpc.SetHeader(ctx, metadata.Pairs("call", strconv.Itoa(int(n))))
+	if n == 1 {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func TestHedgerSendsOneCopyOnTheSameConnection(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	health := &slowFirstHealth{}
+	grpc_health_v1.RegisterHealthServer(s, health)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	h := NewHedger(HedgeConfig{
+		Methods: map[string]bool{"/grpc.health.v1.Health/Check": true},
+		Delay:   50 * time.Millisecond,
+		Rate:    100,
+	})
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(h.UnaryClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var header metadata.MD
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := atomic.LoadInt32(&health.calls); got != 2 {
+		t.Errorf("server saw %d calls, want the call and one copy", got)
+	}
+	if stats := h.Stats(); stats.Calls != 1 || stats.Hedges != 1 || stats.HedgeWins != 1 {
+		t.Errorf("stats = %+v, want 1 call, 1 hedge that won", stats)
+	}
+	if got := header.Get("call"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("header call = %v, want the header of the copy that answered", got)
+	}
+}
@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kekeee-shine/grpc_training/2_interceptors/client/auth"
 	pb "github.com/kekeee-shine/grpc_training/2_interceptors/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
+	"github.com/kekeee-shine/grpc_training/pkg/tracing"
 	"google.golang.org/grpc"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"io"
@@ -13,10 +19,39 @@ import (
 
 const (
 	address = "127.0.0.1:20051"
+	// must match 2_interceptors/server/jwt_keys.json
+	jwtKid    = "dev-2021-06"
+	jwtSecret = "grpc-training-dev-secret"
+)
+
+var (
+	tlsConfig     = tlsutil.ClientFlags()
+	serverAddress = endpoint.AddressFlag(address)
+	serviceConfig = flag.String("service_config", "3_deadlines/client/service_config.json", "service config with the retry policy per method")
 )
 
 func main() {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	flag.Parse()
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	// GetOrder and SearchOrders are retried on Unavailable, the writes are not
+	retryPolicy, err := os.ReadFile(*serviceConfig)
+	if err != nil {
+		log.Fatalf("failed to load service config: %v", err)
+	}
+	// every RPC carries a bearer token whose role claim the server authorizes,
+	// see 2_interceptors/server/policy.json
+	tokens := auth.SignedSource(jwt.SigningMethodHS256, jwtKid, []byte(jwtSecret), "demo-client", "reader", time.Minute*10)
+	// client spans are printed to stdout, the server continues the same trace
+	tracer := tracing.NewTracer(tracing.NewWriterExporter(os.Stdout))
+	conn, err := endpoint.Dial(*serverAddress, transport,
+		grpc.WithDefaultServiceConfig(string(retryPolicy)),
+		grpc.WithPerRPCCredentials(auth.NewJWTCredentials(tokens, tlsConfig.CAFile != "")),
+		grpc.WithChainUnaryInterceptor(tracer.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(tracer.StreamClientInterceptor()))
 	if err != nil {
 		log.Fatalf("did not connect :%v", err)
 	}
@@ -44,7 +79,10 @@ func main() {
 	//log.Printf("GerOrder successfully %v", r)
 
 	// stream request demo
-	searchStream, _ := client.SearchOrders(ctx, &wrapper.StringValue{Value: "Google"})
+	searchStream, err := client.SearchOrders(ctx, &wrapper.StringValue{Value: "Google"})
+	if err != nil {
+		log.Fatalf("Could not search orders: %v", err)
+	}
 	for {
 		searchOrder, err := searchStream.Recv()
 
@@ -0,0 +1,48 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"strings"
+)
+
+// MethodRules match full method names the way the policy writes them: an exact name
+// ("/proto.OrderManagement/getOrder"), a service wildcard ("/grpc.health.v1.Health/*")
+// or "*" for every method.
+type MethodRules []string
+
+// Match reports whether any rule matches fullMethod.
+func (r MethodRules) Match(fullMethod string) bool {
+	for _, rule := range r {
+		switch {
+		case rule == "*", rule == fullMethod:
+			return true
+		case strings.HasSuffix(rule, "/*") && strings.HasPrefix(fullMethod, strings.TrimSuffix(rule, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// ExemptUnary Server :: Unary Interceptor
+// runs interceptor on every call except those of the exempt methods, e.g. the health
+// checks of a load balancer that has no token to send, which go straight down the chain
+func ExemptUnary(exempt MethodRules, interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if exempt.Match(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// ExemptStream Server :: Stream Interceptor
+// is ExemptUnary for streams
+func ExemptStream(exempt MethodRules, interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if exempt.Match(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		return interceptor(srv, ss, info, handler)
+	}
+}
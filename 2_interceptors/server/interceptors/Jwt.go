@@ -0,0 +1,182 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kekeee-shine/grpc_training/pkg/filewatch"
+	"github.com/kekeee-shine/grpc_training/pkg/identity"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Claims is what a bearer token asserts about its caller.
+type Claims struct {
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the token the current RPC was authenticated with.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// KeyConfig is one signing key. HS256 keys carry the shared secret, RS256 keys
+// the path of a PEM encoded public key.
+type KeyConfig struct {
+	Kid           string `json:"kid"`
+	Alg           string `json:"alg"`
+	Secret        string `json:"secret,omitempty"`
+	PublicKeyFile string `json:"public_key_file,omitempty"`
+}
+
+// JWTAuthenticator validates the bearer token in the "authorization" metadata of every RPC.
+// Tokens choose their verification key by the "kid" header, so a new key can be added to
+// the key file and rolled out before the old one is removed.
+type JWTAuthenticator struct {
+	path string
+
+	mu   sync.RWMutex
+	keys map[string]interface{}
+	algs map[string]string
+}
+
+func NewJWTAuthenticator(path string) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the key file again. The current keys are kept if the new file is invalid.
+func (a *JWTAuthenticator) Reload() error {
+	var config struct {
+		Keys []KeyConfig `json:"keys"`
+	}
+	if err := jsonconfig.Load(a.path, &config); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{})
+	algs := make(map[string]string)
+	for _, k := range config.Keys {
+		switch k.Alg {
+		case jwt.SigningMethodHS256.Alg():
+			keys[k.Kid] = []byte(k.Secret)
+		case jwt.SigningMethodRS256.Alg():
+			pemData, err := os.ReadFile(k.PublicKeyFile)
+			if err != nil {
+				return fmt.Errorf("key %s: %v", k.Kid, err)
+			}
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(pemData)
+			if err != nil {
+				return fmt.Errorf("key %s: %v", k.Kid, err)
+			}
+			keys[k.Kid] = publicKey
+		default:
+			return fmt.Errorf("key %s: unsupported alg %q", k.Kid, k.Alg)
+		}
+		algs[k.Kid] = k.Alg
+	}
+
+	a.mu.Lock()
+	a.keys, a.algs = keys, algs
+	a.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the keys whenever the key file is modified, checking every interval until stop is closed.
+func (a *JWTAuthenticator) Watch(interval time.Duration, stop <-chan struct{}) {
+	filewatch.Watch(interval, stop, a.Reload, a.path)
+}
+
+func (a *JWTAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	a.mu.RLock()
+	key, ok := a.keys[kid]
+	alg := a.algs[kid]
+	a.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	// the key decides the algorithm, never the token: this stops an RS256 public key
+	// from being used as an HS256 secret
+	if token.Method.Alg() != alg {
+		return nil, fmt.Errorf("kid %q is not a %s key", kid, token.Method.Alg())
+	}
+	return key, nil
+}
+
+func (a *JWTAuthenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization token")
+	}
+	raw := strings.TrimSpace(values[0])
+	if len(raw) < len("Bearer ") || !strings.EqualFold(raw[:len("Bearer ")], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "authorization is not a bearer token")
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw[len("Bearer "):], claims, a.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	return identity.WithSubject(context.WithValue(ctx, claimsKey{}, claims), claims.Subject), nil
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// authenticates the bearer token and hands its claims to the handler through the context
+func (a *JWTAuthenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx)
+		if err != nil {
+			log.Printf("[JWT Interceptor] %s: %v", info.FullMethod, err)
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// authenticates the bearer token and hands its claims to the handler through the stream context
+func (a *JWTAuthenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context())
+		if err != nil {
+			log.Printf("[JWT Interceptor] %s: %v", info.FullMethod, err)
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// contextStream replaces the context of the embedded grpc.ServerStream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,128 @@
+package interceptors
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/filewatch"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sync"
+	"time"
+)
+
+// Policy maps every role to the full method names it is allowed to call.
+// A rule may be an exact name ("/proto.OrderManagement/getOrder"), a service
+// wildcard ("/proto.OrderManagement/*") or "*" for every method.
+type Policy struct {
+	DefaultRole string              `json:"default_role"`
+	Roles       map[string][]string `json:"roles"`
+}
+
+// Allow reports whether role may call fullMethod.
+func (p *Policy) Allow(role, fullMethod string) bool {
+	return MethodRules(p.Roles[role]).Match(fullMethod)
+}
+
+// PolicyEngine authorizes RPCs against a policy file and reloads it when the file changes.
+type PolicyEngine struct {
+	path string
+
+	mu     sync.RWMutex
+	policy *Policy
+}
+
+func NewPolicyEngine(path string) (*PolicyEngine, error) {
+	e := &PolicyEngine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload reads the policy file again. The current policy is kept if the new one is invalid.
+func (e *PolicyEngine) Reload() error {
+	policy := &Policy{}
+	if err := jsonconfig.Load(e.path, policy); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.policy = policy
+	e.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the policy whenever the file is modified, checking every interval until stop is closed.
+func (e *PolicyEngine) Watch(interval time.Duration, stop <-chan struct{}) {
+	filewatch.Watch(interval, stop, e.Reload, e.path)
+}
+
+// Policy returns the policy currently in force.
+func (e *PolicyEngine) Policy() *Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.policy
+}
+
+func (e *PolicyEngine) authorize(ctx context.Context, fullMethod string) error {
+	policy := e.Policy()
+	role, err := callerRole(ctx)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		role = policy.DefaultRole
+	}
+	if !policy.Allow(role, fullMethod) {
+		return status.Errorf(codes.PermissionDenied, "role %q is not allowed to call %s", role, fullMethod)
+	}
+	return nil
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// rejects the unary calls the caller's role is not allowed to make
+func (e *PolicyEngine) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := e.authorize(ctx, info.FullMethod); err != nil {
+			log.Printf("[Policy Interceptor] %v", err)
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// rejects the streams the caller's role is not allowed to open
+func (e *PolicyEngine) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := e.authorize(ss.Context(), info.FullMethod); err != nil {
+			log.Printf("[Policy Interceptor] %v", err)
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// callerRole takes the role from a verified identity only: the TLS client certificate (its
+// first OU, else its CN), then the role claim of an authenticated bearer token. A caller with
+// neither gets the default role of the policy; what it declares about itself is never trusted.
+// Once a token is verified its claims are the only source of the role, so a token without a
+// role claim is denied rather than given the default role.
+func callerRole(ctx context.Context) (string, error) {
+	if cert, ok := tlsutil.PeerCertificate(ctx); ok {
+		if len(cert.Subject.OrganizationalUnit) > 0 {
+			return cert.Subject.OrganizationalUnit[0], nil
+		}
+		return cert.Subject.CommonName, nil
+	}
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		if claims.Role == "" {
+			return "", status.Error(codes.PermissionDenied, "token has no role claim")
+		}
+		return claims.Role, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,41 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestPolicyEngineRole(t *testing.T) {
+	e := &PolicyEngine{policy: &Policy{
+		DefaultRole: "guest",
+		Roles: map[string][]string{
+			"admin":  {"*"},
+			"reader": {"/proto.OrderManagement/getOrder"},
+			"guest":  {},
+		},
+	}}
+	const method = "/proto.OrderManagement/getOrder"
+	claimed := metadata.NewIncomingContext(context.Background(), metadata.Pairs("role", "admin"))
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{"role claim", context.WithValue(context.Background(), claimsKey{}, &Claims{Role: "reader"}), codes.OK},
+		{"no identity", context.Background(), codes.PermissionDenied},
+		{"role in metadata", claimed, codes.PermissionDenied},
+		{"token without role claim", context.WithValue(context.Background(), claimsKey{}, &Claims{}), codes.PermissionDenied},
+		{"token without role claim and role in metadata", context.WithValue(claimed, claimsKey{}, &Claims{}), codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(e.authorize(tt.ctx, method)); got != tt.want {
+				t.Errorf("authorize = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
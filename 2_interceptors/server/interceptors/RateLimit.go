@@ -0,0 +1,165 @@
+package interceptors
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/identity"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"log"
+	"sync"
+	"time"
+)
+
+// idleBucketTTL is how long the bucket of a silent client is kept before it is dropped.
+const idleBucketTTL = time.Minute * 10
+
+// Limit is a token bucket: Rate tokens per second, at most Burst of them saved up.
+// A zero Rate means unlimited.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig gives every method its own limit per client, and Default to the others.
+type RateLimitConfig struct {
+	Default Limit            `json:"default"`
+	Methods map[string]Limit `json:"methods"`
+}
+
+func LoadRateLimitConfig(path string) (RateLimitConfig, error) {
+	config := RateLimitConfig{}
+	err := jsonconfig.Load(path, &config)
+	return config, err
+}
+
+func (c RateLimitConfig) limit(fullMethod string) Limit {
+	if l, ok := c.Methods[fullMethod]; ok {
+		return l
+	}
+	return c.Default
+}
+
+type bucketKey struct {
+	client, method string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter keeps one token bucket per client, named by identity.Caller, and method. Opening a call takes a token,
+// and so does every message the client sends on a client or bidirectional stream.
+type RateLimiter struct {
+	config RateLimitConfig
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{config: config, buckets: make(map[bucketKey]*bucket), lastSweep: time.Now()}
+}
+
+// take spends one token of the caller's bucket for fullMethod, or returns ResourceExhausted
+// with a RetryInfo telling when the next token is available.
+func (r *RateLimiter) take(ctx context.Context, fullMethod string) error {
+	limit := r.config.limit(fullMethod)
+	if limit.Rate <= 0 {
+		return nil
+	}
+	client := identity.Caller(ctx)
+	reservation := r.bucket(client, fullMethod, limit).Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+	// don't hold the token, the caller is told to come back instead of waiting here
+	reservation.Cancel()
+
+	st := status.Newf(codes.ResourceExhausted, "rate limit of %s exceeded for %s, retry in %v", fullMethod, client, delay)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func (r *RateLimiter) bucket(client, fullMethod string, limit Limit) *rate.Limiter {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastSweep) > idleBucketTTL {
+		for key, b := range r.buckets {
+			if now.Sub(b.lastSeen) > idleBucketTTL {
+				delete(r.buckets, key)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	key := bucketKey{client: client, method: fullMethod}
+	b, ok := r.buckets[key]
+	if !ok {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), burst)}
+		r.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// rejects the calls over the caller's limit of the method
+func (r *RateLimiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := r.take(ctx, info.FullMethod); err != nil {
+			log.Printf("[Rate Limit Interceptor] %v", err)
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// rejects the streams over the caller's limit of the method, and aborts client streams
+// whose messages go over it
+func (r *RateLimiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := r.take(ss.Context(), info.FullMethod); err != nil {
+			log.Printf("[Rate Limit Interceptor] %v", err)
+			return err
+		}
+		if !info.IsClientStream {
+			return handler(srv, ss)
+		}
+		return handler(srv, &rateLimitedStream{ServerStream: ss, limiter: r, method: info.FullMethod})
+	}
+}
+
+// rateLimitedStream spends a token for every message received from the client.
+type rateLimitedStream struct {
+	grpc.ServerStream
+	limiter *RateLimiter
+	method  string
+}
+
+func (s *rateLimitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if err := s.limiter.take(s.Context(), s.method); err != nil {
+		log.Printf("[Rate Limit Interceptor] %v", err)
+		return err
+	}
+	return nil
+}
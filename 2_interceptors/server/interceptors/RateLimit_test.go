@@ -0,0 +1,34 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+)
+
+func TestRateLimiterIgnoresDeclaredClientID(t *testing.T) {
+	const method = "/proto.OrderManagement/getOrder"
+	r := NewRateLimiter(RateLimitConfig{Default: Limit{Rate: 0.001, Burst: 1}})
+	call := func(id string, port int) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("client-id", id))
+		return r.take(ctx, method)
+	}
+
+	if err := call("first", 40001); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	// a new name and a new connection from the same host still share its bucket
+	if err := call("second", 40002); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call = %v, want ResourceExhausted", err)
+	}
+
+	other := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40001}})
+	if err := r.take(other, method); err != nil {
+		t.Errorf("call from another host: %v", err)
+	}
+}
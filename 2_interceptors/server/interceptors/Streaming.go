@@ -1,41 +1,201 @@
 package interceptors
 
 import (
+	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"log"
+	"sync"
 	"time"
 )
 
+// StreamLimit bounds one stream of a method. Zero values mean no limit. MaxDuration becomes
+// the deadline of the stream's context; a handler blocked in Recv sees it once the next
+// message or the end of the client's stream arrives.
+type StreamLimit struct {
+	MaxRecvMsgs int
+	MaxSendMsgs int
+	MaxDuration time.Duration
+}
+
+// StreamStats are the totals of one stream, reported when its handler returns.
+type StreamStats struct {
+	Method    string
+	RecvMsgs  int
+	RecvBytes int
+	SendMsgs  int
+	SendBytes int
+	Duration  time.Duration
+	Err       error
+}
+
 // wrappedStream wraps around the embedded grpc.ServerStream, and intercepts the RecvMsg and
-// SendMsg method call.
+// SendMsg method call. It counts the messages and bytes in both directions and aborts the
+// stream with ResourceExhausted once it goes over its StreamLimit.
 type wrappedStream struct {
 	grpc.ServerStream
+	ctx    context.Context
+	method string
+	limit  StreamLimit
+	start  time.Time
+
+	mu      sync.Mutex
+	stats   StreamStats
+	aborted error
 }
 
 func (w *wrappedStream) RecvMsg(m interface{}) error {
 	log.Printf("====== [Server Stream Interceptor Wrapper] Receive a message (Type: %T) at %s", m, time.Now().Format(time.RFC3339))
-	return w.ServerStream.RecvMsg(m)
+	if err := w.check(); err != nil {
+		return err
+	}
+
+	if err := w.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	// the message may have been waited for past the deadline
+	if err := w.check(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.stats.RecvMsgs++
+	w.stats.RecvBytes += messageSize(m)
+	over := w.limit.MaxRecvMsgs > 0 && w.stats.RecvMsgs > w.limit.MaxRecvMsgs
+	w.mu.Unlock()
+	if over {
+		return w.abort(status.Errorf(codes.ResourceExhausted, "%s exceeded the maximum of %d received messages", w.method, w.limit.MaxRecvMsgs))
+	}
+	return nil
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
 }
 
 func (w *wrappedStream) SendMsg(m interface{}) error {
+	if err := w.check(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	over := w.limit.MaxSendMsgs > 0 && w.stats.SendMsgs >= w.limit.MaxSendMsgs
+	w.mu.Unlock()
+	if over {
+		return w.abort(status.Errorf(codes.ResourceExhausted, "%s exceeded the maximum of %d sent messages", w.method, w.limit.MaxSendMsgs))
+	}
+
 	log.Printf("====== 开始[Server Stream Interceptor Wrapper] 111Send a message (Type: %T) at %v", m, time.Now().Format(time.RFC3339))
 	err := w.ServerStream.SendMsg(m)
 	log.Printf("====== 结束[Server Stream Interceptor Wrapper] 111Send a message (Type: %T) at %v", m, time.Now().Format(time.RFC3339))
+	if err == nil {
+		w.mu.Lock()
+		w.stats.SendMsgs++
+		w.stats.SendBytes += messageSize(m)
+		w.mu.Unlock()
+	}
 	return err
 }
 
-func newWrappedStream(s grpc.ServerStream) grpc.ServerStream {
-	return &wrappedStream{s}
+// check fails every call once the stream was aborted or ran out of time.
+func (w *wrappedStream) check() error {
+	w.mu.Lock()
+	aborted := w.aborted
+	w.mu.Unlock()
+	if aborted != nil {
+		return aborted
+	}
+	if w.expired() {
+		return w.abort(status.Errorf(codes.ResourceExhausted, "%s exceeded the maximum stream duration %v", w.method, w.limit.MaxDuration))
+	}
+	return nil
 }
 
+// expired reports whether the stream ran out of MaxDuration, rather than the client's deadline.
+func (w *wrappedStream) expired() bool {
+	return w.limit.MaxDuration > 0 && time.Since(w.start) >= w.limit.MaxDuration
+}
+
+func (w *wrappedStream) abort(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.aborted == nil {
+		w.aborted = err
+	}
+	return w.aborted
+}
+
+// finish returns the totals, and the abort reason in place of the handler's own error
+// so that the client learns why its stream was cut.
+func (w *wrappedStream) finish(handlerErr error) StreamStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Method = w.method
+	stats.Duration = time.Since(w.start)
+	stats.Err = handlerErr
+	if w.aborted != nil {
+		stats.Err = w.aborted
+	}
+	return stats
+}
+
+func newWrappedStream(s grpc.ServerStream, method string, limit StreamLimit) (*wrappedStream, context.CancelFunc) {
+	w := &wrappedStream{ServerStream: s, ctx: s.Context(), method: method, limit: limit, start: time.Now()}
+	cancel := context.CancelFunc(func() {})
+	if limit.MaxDuration > 0 {
+		w.ctx, cancel = context.WithTimeout(w.ctx, limit.MaxDuration)
+	}
+	return w, cancel
+}
+
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+// OrderServerStreamInterceptor Server :: Stream Interceptor
+// logs and counts every message of the stream, without limits
 func OrderServerStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return serveWrappedStream(srv, ss, info, handler, StreamLimit{}, logStreamStats)
+}
+
+// NewOrderServerStreamInterceptor is OrderServerStreamInterceptor with per method limits.
+// report receives the totals of every stream, nil logs them.
+func NewOrderServerStreamInterceptor(limits map[string]StreamLimit, report func(StreamStats)) grpc.StreamServerInterceptor {
+	if report == nil {
+		report = logStreamStats
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return serveWrappedStream(srv, ss, info, handler, limits[info.FullMethod], report)
+	}
+}
+
+func serveWrappedStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+	limit StreamLimit, report func(StreamStats)) error {
 	// Pre-processing
 	log.Println("====== [Server Stream Interceptor] ", info.FullMethod)
 
 	// Invoking the StreamHandler to complete the execution of RPC invocation
-	err := handler(srv, newWrappedStream(ss))
+	w, cancel := newWrappedStream(ss, info.FullMethod, limit)
+	defer cancel()
+	err := handler(srv, w)
 	if err != nil {
-		log.Printf("RPC failed with error %v", err)
+		// a handler that gave up on the context returns its error, tell the client the reason
+		w.check()
 	}
-	return err
+	stats := w.finish(err)
+	if stats.Err != nil {
+		log.Printf("RPC failed with error %v", stats.Err)
+	}
+	report(stats)
+	return stats.Err
+}
+
+func logStreamStats(s StreamStats) {
+	log.Printf("====== [Server Stream Interceptor] %s done in %v: received %d messages (%d bytes), sent %d messages (%d bytes)",
+		s.Method, s.Duration, s.RecvMsgs, s.RecvBytes, s.SendMsgs, s.SendBytes)
 }
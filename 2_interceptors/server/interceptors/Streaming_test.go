@@ -0,0 +1,80 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// fakeStream hands out an endless run of messages and takes any number of them.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context    { return s.ctx }
+func (s *fakeStream) RecvMsg(m interface{}) error { return nil }
+func (s *fakeStream) SendMsg(m interface{}) error { return nil }
+
+func TestStreamLimits(t *testing.T) {
+	const method = "/proto.OrderManagement/processOrders"
+	tests := []struct {
+		name    string
+		limit   StreamLimit
+		handler grpc.StreamHandler
+	}{
+		{
+			name:  "max duration ends a handler waiting on its context",
+			limit: StreamLimit{MaxDuration: time.Millisecond * 50},
+			handler: func(srv interface{}, ss grpc.ServerStream) error {
+				<-ss.Context().Done()
+				return status.FromContextError(ss.Context().Err()).Err()
+			},
+		},
+		{
+			name:  "max received messages",
+			limit: StreamLimit{MaxRecvMsgs: 3},
+			handler: func(srv interface{}, ss grpc.ServerStream) error {
+				for {
+					if err := ss.RecvMsg(nil); err != nil {
+						return err
+					}
+				}
+			},
+		},
+		{
+			name:  "max sent messages",
+			limit: StreamLimit{MaxSendMsgs: 3},
+			handler: func(srv interface{}, ss grpc.ServerStream) error {
+				for {
+					if err := ss.SendMsg(nil); err != nil {
+						return err
+					}
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats StreamStats
+			interceptor := NewOrderServerStreamInterceptor(map[string]StreamLimit{method: tt.limit}, func(s StreamStats) { stats = s })
+			ss := &fakeStream{ctx: context.Background()}
+			err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: method}, tt.handler)
+			if status.Code(err) != codes.ResourceExhausted {
+				t.Fatalf("stream ended with %v, want ResourceExhausted", err)
+			}
+			if stats.Err != err {
+				t.Errorf("reported %v, returned %v", stats.Err, err)
+			}
+			if tt.limit.MaxRecvMsgs > 0 && stats.RecvMsgs != tt.limit.MaxRecvMsgs+1 {
+				t.Errorf("received %d messages, want the %d allowed and the one over", stats.RecvMsgs, tt.limit.MaxRecvMsgs)
+			}
+			if tt.limit.MaxSendMsgs > 0 && stats.SendMsgs != tt.limit.MaxSendMsgs {
+				t.Errorf("sent %d messages, want %d", stats.SendMsgs, tt.limit.MaxSendMsgs)
+			}
+		})
+	}
+}
@@ -3,10 +3,14 @@ package interceptors
 import (
 	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
-	"strings"
 )
 
+// getOrderMethod is the only method OrderUnaryServerInterceptor2 lets through.
+const getOrderMethod = "/proto.OrderManagement/getOrder"
+
 // OrderUnaryServerInterceptor1 Server :: Unary Interceptor
 // log interceptor
 func OrderUnaryServerInterceptor1(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -25,15 +29,16 @@ func OrderUnaryServerInterceptor1(ctx context.Context, req interface{}, info *gr
 }
 
 // OrderUnaryServerInterceptor2 Server :: Unary Interceptor
-// id check interceptor
+// rejects every method other than getOrder
 func OrderUnaryServerInterceptor2(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	// Pre-processing logic
 	// Gets info about the current RPC call by examining the args passed in
 	log.Println("-------[Server Interceptor 2] ", info.FullMethod)
-	if !strings.Contains(info.FullMethod, "get") {
-		log.Println("just accept the get request")
+	if info.FullMethod != getOrderMethod {
+		log.Printf("reject %s, only %s is accepted", info.FullMethod, getOrderMethod)
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not %s", info.FullMethod, getOrderMethod)
 	}
-	log.Println("this is a get request, pass")
+	log.Println("this is a getOrder request, pass")
 	// Invoking the handler to complete the normal execution of a unary RPC.
 	m, err := handler(ctx, req)
 	log.Println("------- [Server Interceptor 2] End ")
@@ -0,0 +1,37 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestOrderUnaryServerInterceptor2(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		method string
+		want   codes.Code
+	}{
+		{"/proto.OrderManagement/getOrder", codes.OK},
+		{"/proto.OrderManagement/addOrder", codes.PermissionDenied},
+		// names that merely contain "get"
+		{"/proto.OrderManagement/forgetOrder", codes.PermissionDenied},
+		{"/proto.Budget/getOrderTarget", codes.PermissionDenied},
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			_, err := OrderUnaryServerInterceptor2(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
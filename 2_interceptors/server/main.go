@@ -1,12 +1,22 @@
 package main
 
 import (
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/2_interceptors/proto"
 	"github.com/kekeee-shine/grpc_training/2_interceptors/server/interceptors"
 	svc "github.com/kekeee-shine/grpc_training/2_interceptors/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/audit"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"github.com/kekeee-shine/grpc_training/pkg/shutdown"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
+	"github.com/kekeee-shine/grpc_training/pkg/tracing"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"log"
-	"net"
+	"os"
+	"time"
 )
 
 const (
@@ -14,19 +24,112 @@ const (
 	port    = ":20051"
 )
 
+// streamLimits bound every stream of a method, the ones over it are aborted with ResourceExhausted
+var streamLimits = map[string]interceptors.StreamLimit{
+	"/proto.OrderManagement/searchOrders":  {MaxSendMsgs: 100, MaxDuration: time.Second * 30},
+	"/proto.OrderManagement/updateOrders":  {MaxRecvMsgs: 1000, MaxDuration: time.Minute},
+	"/proto.OrderManagement/processOrders": {MaxRecvMsgs: 1000, MaxSendMsgs: 1000, MaxDuration: time.Minute * 5},
+}
+
+// exempt methods skip the token, audit, rate limit and policy checks: load balancers and
+// orchestrators probe health without a token, and reflection only describes the services,
+// whose calls still need one
+var exempt = interceptors.MethodRules{
+	"/grpc.health.v1.Health/*",
+	"/grpc.reflection.v1.ServerReflection/*",
+	"/grpc.reflection.v1alpha.ServerReflection/*",
+}
+
+var (
+	tlsConfig      = tlsutil.ServerFlags()
+	listenAddress  = endpoint.ListenFlag(port)
+	shutdownConfig = shutdown.Flags()
+	policyFile     = flag.String("policy", "2_interceptors/server/policy.json", "role -> allowed methods policy file")
+	jwtKeyFile     = flag.String("jwt_keys", "2_interceptors/server/jwt_keys.json", "keys that verify the bearer tokens")
+	rateFile       = flag.String("rate_limits", "2_interceptors/server/ratelimit.json", "per client and method rate limits")
+	auditFile      = flag.String("audit_log", "audit.log", "hash-chained log of every mutating call")
+)
+
 func main() {
-	// listen the tcp port
-	lis, err := net.Listen("tcp", port)
+	flag.Parse()
+
+	creds, err := tlsConfig.ServerOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+
+	policy, err := interceptors.NewPolicyEngine(*policyFile)
+	if err != nil {
+		log.Fatalf("failed to load policy: %v", err)
+	}
+	authenticator, err := interceptors.NewJWTAuthenticator(*jwtKeyFile)
+	if err != nil {
+		log.Fatalf("failed to load jwt keys: %v", err)
+	}
+	rateConfig, err := interceptors.LoadRateLimitConfig(*rateFile)
+	if err != nil {
+		log.Fatalf("failed to load rate limits: %v", err)
+	}
+	limiter := interceptors.NewRateLimiter(rateConfig)
+	auditLog, err := audit.Open(*auditFile)
+	if err != nil {
+		log.Fatalf("failed to open audit log: %v", err)
+	}
+	auditor := audit.NewAuditor(auditLog, audit.Config{
+		"/proto.OrderManagement/updateOrders": func(req interface{}) string {
+			return "order/" + req.(*pb.Order).Id
+		},
+		"/proto.OrderManagement/processOrders": func(req interface{}) string {
+			return "order/" + req.(*wrapper.StringValue).Value
+		},
+	})
+	// spans are printed to stdout as JSON lines
+	tracer := tracing.NewTracer(tracing.NewWriterExporter(os.Stdout))
+	// reload the policy and keys whenever the files are edited
+	go policy.Watch(time.Second*5, nil)
+	go authenticator.Watch(time.Second*5, nil)
+
+	// listen on tcp, a unix socket or in process
+	lis, err := endpoint.Listen(*listenAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
+	s, hs := newServer(creds, tracer, authenticator, auditor, limiter, policy)
+
+	log.Printf("Starting gRPC listener on %s", *listenAddress)
+	if err := shutdownConfig.Serve(s, lis, hs); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+// newServer registers the order service, health and reflection on a server that runs
+// every call through the interceptors, except those of the exempt methods.
+func newServer(creds grpc.ServerOption, tracer *tracing.Tracer, authenticator *interceptors.JWTAuthenticator,
+	auditor *audit.Auditor, limiter *interceptors.RateLimiter, policy *interceptors.PolicyEngine) (*grpc.Server, *healthcheck.Server) {
 	//s := grpc.NewServer(grpc.UnaryInterceptor(interceptors.OrderUnaryServerInterceptor1),
 	//	grpc.ChainUnaryInterceptor(interceptors.OrderUnaryServerInterceptor2, interceptors.OrderUnaryServerInterceptor3))
-	s := grpc.NewServer(grpc.StreamInterceptor(interceptors.OrderServerStreamInterceptor))
+	s := grpc.NewServer(creds,
+		grpc.ChainUnaryInterceptor(
+			tracer.UnaryServerInterceptor(),
+			interceptors.ExemptUnary(exempt, authenticator.UnaryServerInterceptor()),
+			interceptors.ExemptUnary(exempt, auditor.UnaryServerInterceptor()),
+			interceptors.ExemptUnary(exempt, limiter.UnaryServerInterceptor()),
+			interceptors.ExemptUnary(exempt, policy.UnaryServerInterceptor())),
+		grpc.ChainStreamInterceptor(
+			tracer.StreamServerInterceptor(),
+			interceptors.ExemptStream(exempt, authenticator.StreamServerInterceptor()),
+			interceptors.ExemptStream(exempt, auditor.StreamServerInterceptor()),
+			interceptors.ExemptStream(exempt, limiter.StreamServerInterceptor()),
+			interceptors.ExemptStream(exempt, policy.StreamServerInterceptor()),
+			interceptors.NewOrderServerStreamInterceptor(streamLimits, nil),
+			// innermost, so only messages the limits and the policy accepted are audited
+			auditor.MessageStreamInterceptor()))
 	pb.RegisterOrderManagementServer(s, svc.NewServer())
-	log.Printf("Starting gRPC listener on port " + port)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
-	}
+
+	// health reports SERVING for every service until the server shuts down
+	hs := healthcheck.Register(s)
+	// reflection lets cmd/grpcall and other tools call the services without their .proto files
+	reflection.Register(s)
+	return s, hs
 }
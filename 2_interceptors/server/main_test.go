@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	pb "github.com/kekeee-shine/grpc_training/2_interceptors/proto"
+	"github.com/kekeee-shine/grpc_training/2_interceptors/server/interceptors"
+	"github.com/kekeee-shine/grpc_training/pkg/audit"
+	"github.com/kekeee-shine/grpc_training/pkg/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// dialServer starts the server of main with the policy, keys and rate limits next to
+// this file, and returns a client connection without a token.
+func dialServer(t *testing.T) (*grpc.ClientConn, string) {
+	policy, err := interceptors.NewPolicyEngine("policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := interceptors.NewJWTAuthenticator("jwt_keys.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rateConfig, err := interceptors.LoadRateLimitConfig("ratelimit.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+	auditor := audit.NewAuditor(auditLog, audit.Config{
+		"/proto.OrderManagement/updateOrders": func(req interface{}) string {
+			return "order/" + req.(*pb.Order).Id
+		},
+	})
+	tracer := tracing.NewTracer(tracing.NewWriterExporter(io.Discard))
+
+	s, _ := newServer(grpc.EmptyServerOption{}, tracer, authenticator, auditor, interceptors.NewRateLimiter(rateConfig), policy)
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, auditPath
+}
+
+func TestHealthNeedsNoToken(t *testing.T) {
+	conn, auditPath := dialServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	health := healthpb.NewHealthClient(conn)
+
+	resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: "proto.OrderManagement"})
+	if err != nil {
+		t.Fatalf("Check without a token: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check = %v, want SERVING", resp.Status)
+	}
+
+	watch, err := health.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch without a token: %v", err)
+	}
+	resp, err = watch.Recv()
+	if err != nil {
+		t.Fatalf("Watch without a token: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Watch = %v, want SERVING", resp.Status)
+	}
+
+	// the exemption covers health only, the orders still need a token
+	_, err = pb.NewOrderManagementClient(conn).GetOrder(ctx, &wrapper.StringValue{Value: "102"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetOrder without a token = %v, want Unauthenticated", err)
+	}
+
+	if data, err := os.ReadFile(auditPath); err != nil || len(data) != 0 {
+		t.Errorf("audit log after health checks = %q, %v, want it empty", data, err)
+	}
+}
+
+func TestReflectionNeedsNoToken(t *testing.T) {
+	conn, _ := dialServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatalf("ServerReflectionInfo without a token: %v", err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("ListServices without a token: %v", err)
+	}
+	found := false
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		found = found || service.Name == "proto.OrderManagement"
+	}
+	if !found {
+		t.Errorf("ListServices = %v, want proto.OrderManagement among them", resp.GetListServicesResponse().GetService())
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	pb "github.com/kekeee-shine/grpc_training/2_interceptors/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
@@ -28,7 +29,7 @@ func NewServer() *Server {
 
 //	GetOrder implements proto.OrderManagementServer
 func (s Server) GetOrder(ctx context.Context, value *wrapper.StringValue) (*pb.Order, error) {
-	log.Println("handle GetOrder request : ", value.GetValue())
+	log.Println("handle GetOrder request : ", value.GetValue(), " from : ", tlsutil.PeerIdentity(ctx))
 	order, exists := s.orderMap[value.Value]
 	if exists {
 		return order, status.New(codes.OK, "").Err()
@@ -66,6 +67,9 @@ func (s Server) UpdateOrders(server pb.OrderManagement_UpdateOrdersServer) error
 			// Finished reading the order stream.
 			return server.SendAndClose(&wrapper.StringValue{Value: "Orders processed " + ordersStr})
 		}
+		if err != nil {
+			return err
+		}
 		// Update order
 
 		s.orderMap[order.Id] = order
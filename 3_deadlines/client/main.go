@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
+	"github.com/kekeee-shine/grpc_training/2_interceptors/client/interceptors"
 	pb "github.com/kekeee-shine/grpc_training/3_deadlines/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"log"
@@ -14,8 +18,58 @@ const (
 	address = "127.0.0.1:20051"
 )
 
+var (
+	tlsConfig     = tlsutil.ClientFlags()
+	serverAddress = endpoint.AddressFlag(address)
+	serviceConfig = flag.String("service_config", "3_deadlines/client/service_config.json", "service config with the retry policy per method")
+	hedgeBackend  = flag.String("hedge_backend", "", "second order server that receives the hedged GetOrder, e.g. 127.0.0.1:20052")
+)
+
 func main() {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	flag.Parse()
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	// GetOrder and SearchOrders are retried on Unavailable, the writes are not, see
+	// service_config.json. Run the server with -faults 3_deadlines/server/flaky.json to try it.
+	retryPolicy, err := os.ReadFile(*serviceConfig)
+	if err != nil {
+		log.Fatalf("failed to load service config: %v", err)
+	}
+
+	// after 3 timeouts or Unavailable in a row, calls to the same server and method fail
+	// right away for 10 seconds
+	breaker := interceptors.NewCircuitBreaker(interceptors.BreakerConfig{
+		FailureThreshold: 3,
+		OpenTimeout:      time.Second * 10,
+	})
+
+	// a GetOrder that has not answered within a second is sent again, at most one copy per
+	// second over all calls
+	hedgeConfig := interceptors.HedgeConfig{
+		Methods: map[string]bool{"/proto.OrderManagement/getOrder": true},
+		Delay:   time.Second,
+		Rate:    1,
+		Burst:   1,
+	}
+	if *hedgeBackend != "" {
+		backend, err := endpoint.Dial(*hedgeBackend, transport,
+			grpc.WithUnaryInterceptor(breaker.UnaryClientInterceptor()),
+			grpc.WithStreamInterceptor(breaker.StreamClientInterceptor()))
+		if err != nil {
+			log.Fatalf("did not connect :%v", err)
+		}
+		defer backend.Close()
+		hedgeConfig.Backends = []*grpc.ClientConn{backend}
+	}
+	hedger := interceptors.NewHedger(hedgeConfig)
+
+	conn, err := endpoint.Dial(*serverAddress, transport,
+		grpc.WithDefaultServiceConfig(string(retryPolicy)),
+		grpc.WithChainUnaryInterceptor(hedger.UnaryClientInterceptor(), breaker.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(breaker.StreamClientInterceptor()))
 	if err != nil {
 		log.Fatalf("did not connect :%v", err)
 	}
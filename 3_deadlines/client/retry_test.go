@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	pb "github.com/kekeee-shine/grpc_training/3_deadlines/proto"
+	svc "github.com/kekeee-shine/grpc_training/3_deadlines/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/fault"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const calls = 10
+
+// attempts counts the calls of every method that reach the server.
+type attempts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (a *attempts) add(method string) {
+	a.mu.Lock()
+	a.counts[method]++
+	a.mu.Unlock()
+}
+
+func (a *attempts) get(method string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.counts[method]
+}
+
+// TestRetryPolicy calls the order service behind the faults of server/flaky.json, which
+// fail the first 2 attempts of every call, through a client with service_config.json.
+func TestRetryPolicy(t *testing.T) {
+	// the handlers log every message
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	retryPolicy, err := os.ReadFile("service_config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	faultConfig, err := fault.LoadConfig("../server/flaky.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const failAttempts = 2
+	for m, f := range faultConfig.Methods {
+		if f.FailAttempts != failAttempts {
+			t.Fatalf("flaky.json fails %d attempts of %s, the test expects %d", f.FailAttempts, m, failAttempts)
+		}
+	}
+
+	faults := fault.NewInjector(faultConfig)
+	counted := &attempts{counts: make(map[string]int)}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				counted.add(info.FullMethod)
+				return handler(ctx, req)
+			},
+			faults.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(
+			func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				counted.add(info.FullMethod)
+				return handler(srv, ss)
+			},
+			faults.StreamServerInterceptor()))
+	pb.RegisterOrderManagementServer(s, svc.NewServer(nil))
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(string(retryPolicy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	tests := []struct {
+		method     string
+		idempotent bool
+		call       func(ctx context.Context, i int) error
+	}{
+		{"getOrder", true, func(ctx context.Context, i int) error {
+			_, err := client.GetOrder(ctx, &wrapper.StringValue{Value: "101"})
+			return err
+		}},
+		{"searchOrders", true, func(ctx context.Context, i int) error {
+			stream, err := client.SearchOrders(ctx, &wrapper.StringValue{Value: "Google"})
+			if err != nil {
+				return err
+			}
+			for {
+				if _, err := stream.Recv(); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		}},
+		{"updateOrders", false, func(ctx context.Context, i int) error {
+			stream, err := client.UpdateOrders(ctx)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&pb.Order{Id: "retry-" + strconv.Itoa(i)}); err != nil && err != io.EOF {
+				return err
+			}
+			_, err = stream.CloseAndRecv()
+			return err
+		}},
+		{"processOrders", false, func(ctx context.Context, i int) error {
+			stream, err := client.ProcessOrders(ctx)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&wrapper.StringValue{Value: "101"}); err != nil && err != io.EOF {
+				return err
+			}
+			if err := stream.CloseSend(); err != nil {
+				return err
+			}
+			for {
+				if _, err := stream.Recv(); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			failed := 0
+			for i := 0; i < calls; i++ {
+				// the retries and their backoff have to fit into the deadline of the call
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+				if tt.call(ctx, i) != nil {
+					failed++
+				}
+				cancel()
+			}
+			n := counted.get("/proto.OrderManagement/" + tt.method)
+
+			if tt.idempotent {
+				if failed > 0 || n != calls*(failAttempts+1) {
+					t.Errorf("%d of %d calls failed in %d attempts, want all through retries in %d", failed, calls, n, calls*(failAttempts+1))
+				}
+			} else if failed != calls || n != calls {
+				t.Errorf("%d of %d calls failed in %d attempts, want every call to fail without a retry", failed, calls, n)
+			}
+		})
+	}
+}
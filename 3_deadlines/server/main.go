@@ -1,31 +1,139 @@
 package main
 
 import (
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/3_deadlines/proto"
 	svc "github.com/kekeee-shine/grpc_training/3_deadlines/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/cache"
+	"github.com/kekeee-shine/grpc_training/pkg/concurrency"
+	"github.com/kekeee-shine/grpc_training/pkg/deadline"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/fault"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"github.com/kekeee-shine/grpc_training/pkg/shutdown"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"log"
-	"net"
+	"time"
 )
 
 const (
 	address = "127.0.0.1"
-	port    = ":20051"
+)
+
+var (
+	tlsConfig      = tlsutil.ServerFlags()
+	shutdownConfig = shutdown.Flags()
+	listenAddress  = endpoint.ListenFlag(":20051")
+	faultFile      = flag.String("faults", "3_deadlines/server/faults.json", "latency and errors to inject per method")
+	deadlineFile   = flag.String("deadlines", "3_deadlines/server/deadlines.json", "default and maximum deadline per method")
+	downstream     = flag.String("downstream", "", "order server (plaintext) asked for the orders this one does not have, none if empty")
 )
 
 func main() {
-	// listen the tcp port
-	lis, err := net.Listen("tcp", port)
+	flag.Parse()
+
+	creds, err := tlsConfig.ServerOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+
+	faultConfig, err := fault.LoadConfig(*faultFile)
+	if err != nil {
+		log.Fatalf("failed to load fault config: %v", err)
+	}
+	faults := fault.NewInjector(faultConfig)
+
+	deadlineConfig, err := deadline.LoadConfig(*deadlineFile)
+	if err != nil {
+		log.Fatalf("failed to load deadline config: %v", err)
+	}
+	deadlines := deadline.NewServerDeadlines(deadlineConfig)
+
+	// calls are turned away before they run when their deadline leaves less than the
+	// minimum of the method
+	budget := deadline.NewBudget(deadline.BudgetConfig{
+		MinBudgets: map[string]time.Duration{
+			"/proto.OrderManagement/getOrder":      time.Millisecond * 500,
+			"/proto.OrderManagement/searchOrders":  time.Millisecond * 500,
+			"/proto.OrderManagement/updateOrders":  time.Second,
+			"/proto.OrderManagement/processOrders": time.Second,
+		},
+		Reserve: time.Millisecond * 100,
+	})
+
+	// GetOrder passes the orders it does not have on to the downstream server, with the
+	// deadline of the call minus the reserve
+	var downstreamClient pb.OrderManagementClient
+	if *downstream != "" {
+		conn, err := endpoint.Dial(*downstream, grpc.WithInsecure(),
+			grpc.WithUnaryInterceptor(budget.UnaryClientInterceptor()),
+			grpc.WithStreamInterceptor(budget.StreamClientInterceptor()))
+		if err != nil {
+			log.Fatalf("failed to dial downstream: %v", err)
+		}
+		defer conn.Close()
+		downstreamClient = pb.NewOrderManagementClient(conn)
+	}
+
+	// calls should finish within 2 seconds: while faults.json delays GetOrder by 8 seconds
+	// the limit backs off towards Min and the excess is shed, and it grows again once the
+	// server is started without that delay
+	limiter := concurrency.NewAdaptiveLimiter(concurrency.Config{
+		Initial:       10,
+		Min:           2,
+		Max:           50,
+		LatencyTarget: time.Second * 2,
+		Backoff:       0.9,
+	})
+	// a repeated GetOrder is answered from memory instead of waiting 8 seconds again,
+	// until UpdateOrders rewrites the order. It sits inside the deadline and budget checks,
+	// so a hit still gets its deadline enforced, but skips the limiter and the faults
+	responseCache := cache.NewResponseCache(cache.Config{
+		TTLs: map[string]time.Duration{
+			"/proto.OrderManagement/getOrder": time.Minute,
+		},
+		Invalidations: map[string]func(req, resp interface{}) []cache.StaleRead{
+			"/proto.OrderManagement/updateOrders": func(req, resp interface{}) []cache.StaleRead {
+				order := req.(*pb.Order)
+				return []cache.StaleRead{{Method: "/proto.OrderManagement/getOrder", Request: &wrapper.StringValue{Value: order.Id}}}
+			},
+		},
+	})
+	go responseCache.Report(time.Minute, nil)
+
+	// listen on tcp, a unix socket or in process
+	lis, err := endpoint.Listen(*listenAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
 	//s := grpc.NewServer(grpc.UnaryInterceptor(interceptors.OrderUnaryServerInterceptor1),
 	//	grpc.ChainUnaryInterceptor(interceptors.OrderUnaryServerInterceptor2, interceptors.OrderUnaryServerInterceptor3))
-	s := grpc.NewServer()
-	pb.RegisterOrderManagementServer(s, svc.NewServer())
-	log.Printf("Starting gRPC listener on port " + port)
-	if err := s.Serve(lis); err != nil {
+	s := grpc.NewServer(creds,
+		grpc.ChainUnaryInterceptor(
+			deadlines.UnaryServerInterceptor(),
+			budget.UnaryServerInterceptor(),
+			responseCache.UnaryServerInterceptor(),
+			limiter.UnaryServerInterceptor(),
+			faults.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(
+			deadlines.StreamServerInterceptor(),
+			budget.StreamServerInterceptor(),
+			responseCache.StreamServerInterceptor(),
+			limiter.StreamServerInterceptor(),
+			faults.StreamServerInterceptor()))
+	pb.RegisterOrderManagementServer(s, svc.NewServer(downstreamClient))
+
+	// health reports SERVING for every service until the server shuts down
+	hs := healthcheck.Register(s)
+	// reflection lets cmd/grpcall and other tools call the services without their .proto files
+	reflection.Register(s)
+
+	log.Printf("Starting gRPC listener on %s", *listenAddress)
+	if err := shutdownConfig.Serve(s, lis, hs); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
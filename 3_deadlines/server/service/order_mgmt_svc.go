@@ -3,44 +3,51 @@ package service
 import (
 	"context"
 	pb "github.com/kekeee-shine/grpc_training/3_deadlines/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/deadline"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"io"
 	"log"
 	"strings"
-	"time"
 )
 
 type Server struct {
 	orderMap map[string]*pb.Order
+	// downstream is asked for the orders this server does not have, if set
+	downstream pb.OrderManagementClient
 	pb.OrderManagementServer
 }
 
-func NewServer() *Server {
+func NewServer(downstream pb.OrderManagementClient) *Server {
 	orderMap := make(map[string]*pb.Order)
 	orderMap["101"] = &pb.Order{Id: "101", Items: []string{"Google Pixel 3A", "Mac Book Pro"}, Destination: "Mountain View, CA", Price: 1800.00}
 	orderMap["102"] = &pb.Order{Id: "102", Items: []string{"Apple Watch S4"}, Destination: "San Jose, CA", Price: 400.00}
 	orderMap["103"] = &pb.Order{Id: "103", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00}
 	orderMap["104"] = &pb.Order{Id: "104", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00}
 	orderMap["105"] = &pb.Order{Id: "105", Items: []string{"Amazon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 30.00}
-	return &Server{orderMap: orderMap}
+	return &Server{orderMap: orderMap, downstream: downstream}
 }
 
 //	GetOrder implements proto.OrderManagementServer
 func (s Server) GetOrder(ctx context.Context, value *wrapper.StringValue) (*pb.Order, error) {
-	time.Sleep(time.Second * 8)
-
 	if ctx.Err() == context.DeadlineExceeded {
 		log.Printf("RPC has reached deadline exceeded state : %s", ctx.Err())
 		return nil, ctx.Err()
 	}
+	if budget, ok := deadline.Remaining(ctx); ok {
+		log.Printf("GetOrder has %v left of its deadline", budget)
+	}
 
 	log.Println("Handle GetOrder request : ", value.GetValue())
 	order, exists := s.orderMap[value.Value]
 	if exists {
 		return order, status.New(codes.OK, "").Err()
 	}
+	if s.downstream != nil {
+		// the context carries the budget, so the downstream deadline leaves time to answer
+		return s.downstream.GetOrder(ctx, value)
+	}
 	return nil, status.Newf(codes.NotFound, "order %v is not found", value.String()).Err()
 }
 
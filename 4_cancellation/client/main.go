@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/4_cancellation/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"io"
@@ -15,8 +18,26 @@ const (
 	address = "127.0.0.1:20051"
 )
 
+var (
+	tlsConfig     = tlsutil.ClientFlags()
+	serverAddress = endpoint.AddressFlag(address)
+	serviceConfig = flag.String("service_config", "3_deadlines/client/service_config.json", "service config with the retry policy per method")
+)
+
 func main() {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	flag.Parse()
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	// GetOrder and SearchOrders are retried on Unavailable, the writes are not
+	retryPolicy, err := os.ReadFile(*serviceConfig)
+	if err != nil {
+		log.Fatalf("failed to load service config: %v", err)
+	}
+	conn, err := endpoint.Dial(*serverAddress, transport,
+		grpc.WithDefaultServiceConfig(string(retryPolicy)))
 	if err != nil {
 		log.Fatalf("did not connect :%v", err)
 	}
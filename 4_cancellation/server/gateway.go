@@ -0,0 +1,53 @@
+package main
+
+import (
+	pb "github.com/kekeee-shine/grpc_training/4_cancellation/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/gateway"
+	"google.golang.org/grpc"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net/http"
+)
+
+// gatewayRoutes maps the HTTP/JSON endpoints onto OrderManagement:
+//
+//	GET /v1/orders/{id}         getOrder
+//	GET /v1/orders?query=Apple  searchOrders, as newline-delimited JSON
+func gatewayRoutes(conn *grpc.ClientConn) http.Handler {
+	client := pb.NewOrderManagementClient(conn)
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		order, err := client.GetOrder(gateway.Context(r), &wrapper.StringValue{Value: r.PathValue("id")})
+		if err != nil {
+			gateway.WriteError(w, err)
+			return
+		}
+		gateway.WriteMessage(w, order)
+	})
+
+	mux.HandleFunc("GET /v1/orders", func(w http.ResponseWriter, r *http.Request) {
+		out := gateway.NewStream(w)
+		stream, err := client.SearchOrders(gateway.Context(r), &wrapper.StringValue{Value: r.URL.Query().Get("query")})
+		if err != nil {
+			out.Close(err)
+			return
+		}
+		for {
+			order, err := stream.Recv()
+			if err == io.EOF {
+				out.Close(nil)
+				return
+			}
+			if err != nil {
+				out.Close(err)
+				return
+			}
+			if err := out.Send(order); err != nil {
+				// the HTTP client is gone, which cancels the stream as well
+				return
+			}
+		}
+	})
+	return mux
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	pb "github.com/kekeee-shine/grpc_training/4_cancellation/proto"
+	svc "github.com/kekeee-shine/grpc_training/4_cancellation/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/gateway"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	dashboard = "https://dashboard.example"
+	// trailerFlag marks the last frame of a response, which carries the trailers
+	trailerFlag = 0x80
+)
+
+// webClient speaks the gRPC-Web wire format: length-prefixed frames in the body of a POST,
+// base64 encoded in text mode, with the status in a trailer frame.
+type webClient struct {
+	base string
+	text bool
+}
+
+// webResponse is the outcome of a call.
+type webResponse struct {
+	messages [][]byte
+	code     codes.Code
+	message  string
+	header   http.Header
+}
+
+func (c *webClient) contentType() string {
+	if c.text {
+		return "application/grpc-web-text"
+	}
+	return "application/grpc-web+proto"
+}
+
+func (c *webClient) call(method string, req proto.Message, origin string) (*webResponse, error) {
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	body := frame(0, payload)
+	if c.text {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+
+	r, err := http.NewRequest(http.MethodPost, c.base+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", c.contentType())
+	r.Header.Set("Accept", c.contentType())
+	r.Header.Set("X-Grpc-Web", "1")
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if c.text {
+		if data, err = decodeText(data); err != nil {
+			return nil, err
+		}
+	}
+
+	out := &webResponse{header: resp.Header}
+	// a call that fails before its first message may carry its status in the headers
+	trailers := textproto.MIMEHeader(resp.Header)
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, fmt.Errorf("truncated frame header")
+		}
+		flag, n := data[0], binary.BigEndian.Uint32(data[1:5])
+		if uint32(len(data)-5) < n {
+			return nil, fmt.Errorf("truncated frame of %d bytes", n)
+		}
+		content := data[5 : 5+n]
+		data = data[5+n:]
+		if flag&trailerFlag == 0 {
+			out.messages = append(out.messages, content)
+			continue
+		}
+		if trailers, err = parseTrailers(content); err != nil {
+			return nil, err
+		}
+	}
+	code, err := strconv.Atoi(trailers.Get("Grpc-Status"))
+	if err != nil {
+		return nil, fmt.Errorf("no grpc-status in the response")
+	}
+	out.code, out.message = codes.Code(code), trailers.Get("Grpc-Message")
+	return out, nil
+}
+
+func frame(flag byte, payload []byte) []byte {
+	b := make([]byte, 5+len(payload))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(payload)))
+	copy(b[5:], payload)
+	return b
+}
+
+// decodeText decodes a text mode body. The server encodes every write on its own, so the
+// body is a run of padded base64 chunks, decoded 4 characters at a time.
+func decodeText(data []byte) ([]byte, error) {
+	var out []byte
+	for i := 0; i+4 <= len(data); i += 4 {
+		b, err := base64.StdEncoding.DecodeString(string(data[i : i+4]))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("text body of %d bytes is not base64", len(data))
+	}
+	return out, nil
+}
+
+// parseTrailers reads the "key: value\r\n" lines of a trailer frame.
+func parseTrailers(content []byte) (textproto.MIMEHeader, error) {
+	trailers := make(textproto.MIMEHeader)
+	for _, line := range strings.Split(string(content), "\r\n") {
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed trailer %q", line)
+		}
+		trailers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return trailers, nil
+}
+
+func preflight(base, method, origin string) (http.Header, error) {
+	r, err := http.NewRequest(http.MethodOptions, base+method, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Header, nil
+}
+
+// startGateway serves the order service with the gateway on a local port and returns its
+// base URL.
+func startGateway(t *testing.T) string {
+	t.Helper()
+	// the handlers log every message
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	orders := svc.NewServer()
+	pb.RegisterOrderManagementServer(s, orders)
+	config := &gateway.Config{Enabled: true, WebOrigins: []string{dashboard}}
+	lis, gw, err := config.Serve(s, lis, gatewayRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	t.Cleanup(orders.Close)
+	t.Cleanup(s.Stop)
+	t.Cleanup(func() { gw.Shutdown(context.Background()) })
+	return "http://" + lis.Addr().String()
+}
+
+func TestGRPCWeb(t *testing.T) {
+	base := startGateway(t)
+	for _, text := range []bool{false, true} {
+		c := &webClient{base: base, text: text}
+		t.Run(c.contentType(), func(t *testing.T) {
+			resp, err := c.call("/proto.OrderManagement/getOrder", &wrapper.StringValue{Value: "102"}, "")
+			if err != nil {
+				t.Fatalf("getOrder failed: %v", err)
+			}
+			if resp.code != codes.OK || len(resp.messages) != 1 {
+				t.Fatalf("getOrder returned %v %q with %d messages", resp.code, resp.message, len(resp.messages))
+			}
+			order := &pb.Order{}
+			if err := proto.Unmarshal(resp.messages[0], order); err != nil || order.Id != "102" {
+				t.Fatalf("getOrder returned order %v (%v), want 102", order, err)
+			}
+
+			resp, err = c.call("/proto.OrderManagement/searchOrders", &wrapper.StringValue{Value: "Google"}, "")
+			if err != nil {
+				t.Fatalf("searchOrders failed: %v", err)
+			}
+			if resp.code != codes.OK || len(resp.messages) != 2 {
+				t.Fatalf("searchOrders returned %v %q with %d messages, want 2", resp.code, resp.message, len(resp.messages))
+			}
+
+			resp, err = c.call("/proto.OrderManagement/getOrder", &wrapper.StringValue{Value: "999"}, "")
+			if err != nil {
+				t.Fatalf("getOrder of a missing order failed: %v", err)
+			}
+			if resp.code != codes.NotFound {
+				t.Fatalf("getOrder of a missing order returned %v, want NotFound", resp.code)
+			}
+		})
+	}
+}
+
+func TestGRPCWebCORS(t *testing.T) {
+	base := startGateway(t)
+	header, err := preflight(base, "/proto.OrderManagement/getOrder", dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("Access-Control-Allow-Origin"); got != dashboard {
+		t.Errorf("preflight from %s allowed origin %q", dashboard, got)
+	}
+	header, err = preflight(base, "/proto.OrderManagement/getOrder", "https://elsewhere.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight from a foreign origin allowed origin %q", got)
+	}
+	resp, err := (&webClient{base: base}).call("/proto.OrderManagement/getOrder", &wrapper.StringValue{Value: "102"}, dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.header.Get("Access-Control-Allow-Origin"); got != dashboard {
+		t.Errorf("getOrder from %s allowed origin %q", dashboard, got)
+	}
+}
+
+func TestGatewayGetOrder(t *testing.T) {
+	base := startGateway(t)
+	resp, err := http.Get(base + "/v1/orders/102")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /v1/orders/102 = %s: %s", resp.Status, body)
+	}
+	order := &pb.Order{}
+	if err := protojson.Unmarshal(body, order); err != nil || order.Id != "102" {
+		t.Errorf("GET /v1/orders/102 returned %s (%v)", body, err)
+	}
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/4_cancellation/proto"
 	svc "github.com/kekeee-shine/grpc_training/4_cancellation/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/cleanup"
+	"github.com/kekeee-shine/grpc_training/pkg/deadline"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/fault"
+	"github.com/kekeee-shine/grpc_training/pkg/gateway"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"github.com/kekeee-shine/grpc_training/pkg/shutdown"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"log"
-	"net"
 )
 
 const (
@@ -13,17 +23,80 @@ const (
 	port    = ":20051"
 )
 
+var (
+	tlsConfig      = tlsutil.ServerFlags()
+	listenAddress  = endpoint.ListenFlag(port)
+	shutdownConfig = shutdown.Flags()
+	gatewayConfig  = gateway.Flags()
+	faultFile      = flag.String("faults", "4_cancellation/server/faults.json", "latency and errors to inject per method")
+	deadlineFile   = flag.String("deadlines", "4_cancellation/server/deadlines.json", "default and maximum deadline per method")
+)
+
 func main() {
-	// listen the tcp port
-	lis, err := net.Listen("tcp", port)
+	flag.Parse()
+
+	creds, err := tlsConfig.ServerOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+
+	faultConfig, err := fault.LoadConfig(*faultFile)
+	if err != nil {
+		log.Fatalf("failed to load fault config: %v", err)
+	}
+	faults := fault.NewInjector(faultConfig)
+
+	deadlineConfig, err := deadline.LoadConfig(*deadlineFile)
+	if err != nil {
+		log.Fatalf("failed to load deadline config: %v", err)
+	}
+	deadlines := deadline.NewServerDeadlines(deadlineConfig)
+
+	// listen on tcp, a unix socket or in process
+	lis, err := endpoint.Listen(*listenAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterOrderManagementServer(s, svc.NewServer())
-	log.Printf("Starting gRPC listener on port " + port)
-	if err := s.Serve(lis); err != nil {
+	s := grpc.NewServer(creds,
+		grpc.ChainUnaryInterceptor(
+			cleanup.UnaryServerInterceptor,
+			deadlines.UnaryServerInterceptor(),
+			faults.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(
+			cleanup.StreamServerInterceptor,
+			deadlines.StreamServerInterceptor(),
+			faults.StreamServerInterceptor()))
+	orders := svc.NewServer()
+	pb.RegisterOrderManagementServer(s, orders)
+
+	// health reports SERVING for every service until the server shuts down
+	hs := healthcheck.Register(s)
+	// reflection lets cmd/grpcall and other tools call the services without their .proto files
+	reflection.Register(s)
+
+	// HTTP/1.1 connections on the port go to the gateway and gRPC-Web, HTTP/2 ones to gRPC
+	var gw *gateway.Server
+	if gatewayConfig.Enabled && tlsConfig.CertFile != "" {
+		log.Printf("the HTTP gateway needs a server without TLS, serving gRPC only")
+	} else if gatewayConfig.Enabled {
+		lis, gw, err = gatewayConfig.Serve(s, lis, gatewayRoutes)
+		if err != nil {
+			log.Fatalf("failed to start the gateway: %v", err)
+		}
+	}
+
+	log.Printf("Starting gRPC listener on %s", *listenAddress)
+	if err := shutdownConfig.Serve(s, lis, hs); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	if gw != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownConfig.DrainTimeout)
+		defer cancel()
+		if err := gw.Shutdown(ctx); err != nil {
+			log.Printf("failed to drain the gateway: %v", err)
+		}
+	}
+	// no ProcessOrders runs anymore
+	orders.Close()
 }
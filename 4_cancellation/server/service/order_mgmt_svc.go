@@ -3,17 +3,28 @@ package service
 import (
 	"context"
 	pb "github.com/kekeee-shine/grpc_training/4_cancellation/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/cleanup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"io"
 	"log"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// workers and queueSize bound the order processing of all ProcessOrders streams together
+	workers        = 4
+	queueSize      = 16
+	processingTime = time.Millisecond * 500
+)
+
 type Server struct {
+	mu       *sync.Mutex
 	orderMap map[string]*pb.Order
+	pool     *WorkerPool
 	pb.OrderManagementServer
 }
 
@@ -24,20 +35,25 @@ func NewServer() *Server {
 	orderMap["103"] = &pb.Order{Id: "103", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00}
 	orderMap["104"] = &pb.Order{Id: "104", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00}
 	orderMap["105"] = &pb.Order{Id: "105", Items: []string{"Amazon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 30.00}
-	return &Server{orderMap: orderMap}
+	return &Server{mu: &sync.Mutex{}, orderMap: orderMap, pool: NewWorkerPool(workers, queueSize)}
+}
+
+// Close stops the workers of ProcessOrders, once the server has stopped.
+func (s *Server) Close() {
+	s.pool.Close()
 }
 
 //	GetOrder implements proto.OrderManagementServer
 func (s Server) GetOrder(ctx context.Context, value *wrapper.StringValue) (*pb.Order, error) {
-	time.Sleep(time.Second * 8)
-
 	if ctx.Err() == context.DeadlineExceeded {
 		log.Printf("RPC has reached deadline exceeded state : %s", ctx.Err())
 		return nil, ctx.Err()
 	}
 
 	log.Println("Handle GetOrder request : ", value.GetValue())
+	s.mu.Lock()
 	order, exists := s.orderMap[value.Value]
+	s.mu.Unlock()
 	if exists {
 		return order, status.New(codes.OK, "").Err()
 	}
@@ -47,19 +63,31 @@ func (s Server) GetOrder(ctx context.Context, value *wrapper.StringValue) (*pb.O
 //	SearchOrders implements proto.OrderManagementServer
 func (s Server) SearchOrders(value *wrapper.StringValue, server pb.OrderManagement_SearchOrdersServer) error {
 	log.Println("Handle SearchOrders request : ", value.GetValue())
-	for key, order := range s.orderMap {
+	s.mu.Lock()
+	matches := make([]*pb.Order, 0)
+	for _, order := range s.orderMap {
 		for _, itemStr := range order.Items {
 			if strings.Contains(itemStr, value.Value) {
-				// Send the matching orders in a stream
-				log.Print("Matching Order Found : "+key, " -> Writing Order to the stream ... ")
-				err := server.Send(order)
-				if err != nil {
-					return err
-				}
+				matches = append(matches, order)
 				break
 			}
 		}
 	}
+	s.mu.Unlock()
+
+	for _, order := range matches {
+		// Stop writing as soon as the client is gone
+		if err := server.Context().Err(); err != nil {
+			log.Printf("SearchOrders cancelled after a partial result: %v", err)
+			return status.FromContextError(err).Err()
+		}
+		// Send the matching orders in a stream
+		log.Print("Matching Order Found : "+order.Id, " -> Writing Order to the stream ... ")
+		err := server.Send(order)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -67,56 +95,173 @@ func (s Server) SearchOrders(value *wrapper.StringValue, server pb.OrderManageme
 func (s Server) UpdateOrders(server pb.OrderManagement_UpdateOrdersServer) error {
 
 	ordersStr := "Updated Order IDs : "
+	// the batch is staged here and only reaches orderMap once the whole stream arrived,
+	// so readers never see part of a batch the client gives up on
+	batch := make(map[string]*pb.Order)
+	cleanup.OnRollback(server.Context(), func() {
+		log.Printf("UpdateOrders discarded a partial batch of %d orders", len(batch))
+	})
 	for {
 		order, err := server.Recv()
 		log.Printf("Handle UpdateOrders request %v : ", order)
 		if err == io.EOF {
-			// Finished reading the order stream.
+			// Finished reading the order stream, commit the batch at once.
+			s.mu.Lock()
+			for id, order := range batch {
+				s.orderMap[id] = order
+			}
+			s.mu.Unlock()
 			return server.SendAndClose(&wrapper.StringValue{Value: "Orders processed " + ordersStr})
 		}
-		// Update order
-
-		s.orderMap[order.Id] = order
+		if err != nil {
+			// the client cancelled or went away, Recv reports it
+			log.Printf("UpdateOrders stopped: %v", err)
+			return err
+		}
+		// Stage order
+		batch[order.Id] = order
 
 		ordersStr += order.Id + ", "
 	}
 }
 
 //	ProcessOrders implements proto.OrderManagementServer
+// Every order ID is processed on the worker pool as soon as it arrives, and its shipment
+// is sent back as soon as it is done, in the order the work completes.
 func (s Server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
-	tmpIdArray := make([]string, 0)
-	for {
+	ctx := stream.Context()
+	shipments := newOutbox()
+	var pending sync.WaitGroup
 
-		// You can determine whether the current RPC is cancelled by the other party.
-		if stream.Context().Err() == context.Canceled {
-			log.Printf(" Context Cacelled for this stream: -> %s", stream.Context().Err())
-			log.Printf("Stopped processing any more order of this stream!")
-			return stream.Context().Err()
+	// only this goroutine sends, while the handler keeps receiving
+	sent := make(chan error, 1)
+	go func() {
+		var err error
+		for {
+			ids, open := shipments.take()
+			for _, id := range ids {
+				if err == nil {
+					err = stream.Send(&wrapper.StringValue{Value: id})
+				}
+			}
+			if !open {
+				break
+			}
 		}
+		sent <- err
+	}()
+	finish := func() error {
+		// cancelled jobs return right away, so this does not outlive the stream for long
+		pending.Wait()
+		shipments.close()
+		return <-sent
+	}
 
+	for {
 		orderId, err := stream.Recv()
 
-		log.Printf("Handle ProcessOrders request %s : ", orderId)
 		if err == io.EOF {
 			// Client has sent all the messages
 			// Send remaining shipments
-
 			log.Println("EOF ", orderId)
-
-			for Id := range tmpIdArray {
-				err := stream.Send(&wrapper.StringValue{Value: tmpIdArray[Id]})
-				if err != nil {
-					return nil
-				}
+			if err := finish(); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return status.FromContextError(err).Err()
 			}
 			log.Println("ProcessOrders send end ")
 			return nil
-		} else {
-			tmpIdArray = append(tmpIdArray, orderId.Value)
 		}
 		if err != nil {
+			// You can determine whether the current RPC is cancelled by the other party.
+			if ctx.Err() == context.Canceled {
+				log.Printf(" Context Cacelled for this stream: -> %s", ctx.Err())
+				log.Printf("Stopped processing any more order of this stream!")
+			}
 			log.Println(err)
+			finish()
 			return err
 		}
+		log.Printf("Handle ProcessOrders request %s : ", orderId)
+
+		id := orderId.Value
+		pending.Add(1)
+		err = s.pool.Submit(ctx, func(ctx context.Context) {
+			if s.processOrder(ctx, id) == nil {
+				shipments.put(id)
+			}
+		}, pending.Done)
+		if err == ErrPoolClosed {
+			pending.Done()
+			finish()
+			return status.Error(codes.Unavailable, "server is stopping")
+		}
+		if err != nil {
+			pending.Done()
+			finish()
+			return status.FromContextError(err).Err()
+		}
+	}
+}
+
+// processOrder stands in for the real work on an order, which takes processingTime.
+func (s Server) processOrder(ctx context.Context, id string) error {
+	select {
+	case <-time.After(processingTime):
+	case <-ctx.Done():
+		log.Printf("Processing of order %s aborted: %v", id, ctx.Err())
+		return ctx.Err()
+	}
+	log.Printf("Order %s processed", id)
+	return nil
+}
+
+// outbox holds the shipments of one ProcessOrders stream until its sender writes them, so
+// that the shared workers never wait on a slow client.
+type outbox struct {
+	mu     sync.Mutex
+	ids    []string
+	closed bool
+	ready  chan struct{}
+}
+
+func newOutbox() *outbox {
+	return &outbox{ready: make(chan struct{}, 1)}
+}
+
+func (o *outbox) put(id string) {
+	o.mu.Lock()
+	o.ids = append(o.ids, id)
+	o.mu.Unlock()
+	o.signal()
+}
+
+// close tells the sender no more shipments follow the ones already put.
+func (o *outbox) close() {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+	o.signal()
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take waits for shipments and returns them, and false once the outbox is closed and empty.
+func (o *outbox) take() ([]string, bool) {
+	for {
+		o.mu.Lock()
+		ids, closed := o.ids, o.closed
+		o.ids = nil
+		o.mu.Unlock()
+		if len(ids) > 0 || closed {
+			return ids, !closed
+		}
+		<-o.ready
 	}
 }
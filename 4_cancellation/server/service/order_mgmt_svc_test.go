@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/kekeee-shine/grpc_training/4_cancellation/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/cleanup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const rounds = 50
+
+// startServer serves the order service in process and returns a client of it.
+func startServer(t *testing.T) pb.OrderManagementClient {
+	t.Helper()
+	// the handlers log every message
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(cleanup.UnaryServerInterceptor),
+		grpc.StreamInterceptor(cleanup.StreamServerInterceptor))
+	orders := NewServer()
+	pb.RegisterOrderManagementServer(s, orders)
+	go s.Serve(lis)
+	t.Cleanup(orders.Close)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewOrderManagementClient(conn)
+}
+
+// eventually retries check until it passes or the handlers had 5 seconds to stop.
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestCancelledStreamsDoNotLeak(t *testing.T) {
+	client := startServer(t)
+	// the first call sets up the connection, its goroutines belong to the baseline
+	if _, err := client.GetOrder(context.Background(), &wrapper.StringValue{Value: "101"}); err != nil {
+		t.Fatal(err)
+	}
+	baseline := runtime.NumGoroutine()
+
+	for i := 0; i < rounds; i++ {
+		cancelSearch(t, client)
+		cancelUpdate(t, client, i)
+		cancelProcess(t, client)
+	}
+
+	eventually(t, func() error {
+		if n := runtime.NumGoroutine(); n > baseline {
+			if testing.Verbose() {
+				pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+			}
+			return fmt.Errorf("%d goroutines leaked after %d rounds", n-baseline, rounds)
+		}
+		return nil
+	})
+}
+
+func TestCancelledUpdateOrdersChangesNothing(t *testing.T) {
+	client := startServer(t)
+	original, err := client.GetOrder(context.Background(), &wrapper.StringValue{Value: "101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < rounds; i++ {
+		cancelUpdate(t, client, i)
+		order, err := client.GetOrder(context.Background(), &wrapper.StringValue{Value: "101"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Destination != original.Destination {
+			t.Fatalf("order 101 ships to %q during a cancelled UpdateOrders", order.Destination)
+		}
+		_, err = client.GetOrder(context.Background(), &wrapper.StringValue{Value: fmt.Sprintf("leak-%d", i)})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("order leak-%d of a cancelled UpdateOrders exists: %v", i, err)
+		}
+	}
+}
+
+func TestCompletedUpdateOrdersCommits(t *testing.T) {
+	client := startServer(t)
+	stream, err := client.UpdateOrders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range []*pb.Order{{Id: "101", Destination: "updated"}, {Id: "201", Destination: "new"}} {
+		if err := stream.Send(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]string{"101": "updated", "201": "new"} {
+		order, err := client.GetOrder(context.Background(), &wrapper.StringValue{Value: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Destination != want {
+			t.Errorf("order %s ships to %q, want %q", id, order.Destination, want)
+		}
+	}
+}
+
+func cancelSearch(t *testing.T, client pb.OrderManagementClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.SearchOrders(ctx, &wrapper.StringValue{Value: "Google"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cancelUpdate(t *testing.T, client pb.OrderManagementClient, round int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.UpdateOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := []*pb.Order{
+		{Id: "101", Items: []string{"Google Pixel 3A"}, Destination: "cancelled"},
+		{Id: fmt.Sprintf("leak-%d", round), Items: []string{"Amazon Echo"}, Destination: "cancelled"},
+	}
+	for _, order := range orders {
+		if err := stream.Send(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cancel before CloseAndRecv: the server has received some orders and must drop them
+	time.Sleep(time.Millisecond * 10)
+}
+
+func cancelProcess(t *testing.T, client pb.OrderManagementClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"101", "102", "103"} {
+		if err := stream.Send(&wrapper.StringValue{Value: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOutboxNeverBlocksWorkers(t *testing.T) {
+	box := newOutbox()
+	done := make(chan struct{})
+	go func() {
+		// nobody takes: the sender of a slow client is stuck in Send
+		for i := 0; i < workers*queueSize; i++ {
+			box.put(strconv.Itoa(i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("put blocked while the sender was busy")
+	}
+
+	box.close()
+	ids, open := box.take()
+	if len(ids) != workers*queueSize || open {
+		t.Errorf("take = %d shipments, open %v, want all %d and closed", len(ids), open, workers*queueSize)
+	}
+}
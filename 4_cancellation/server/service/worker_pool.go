@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned by Submit once the pool is closed.
+var ErrPoolClosed = errors.New("worker pool closed")
+
+// job is one work item of a stream. Its context is the stream's, so a cancelled stream
+// skips its queued jobs and aborts its running ones.
+type job struct {
+	ctx  context.Context
+	work func(ctx context.Context)
+	done func()
+}
+
+// WorkerPool runs the work items of all streams on a fixed number of goroutines, behind a
+// bounded queue.
+type WorkerPool struct {
+	jobs chan job
+	wg   sync.WaitGroup
+
+	// mu is held for reading by every Submit, so that Close closes jobs after the last one
+	mu        sync.RWMutex
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewWorkerPool(workers, queue int) *WorkerPool {
+	p := &WorkerPool{jobs: make(chan job, queue), closed: make(chan struct{})}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.run()
+	}
+	return p
+}
+
+func (p *WorkerPool) run() {
+	defer p.wg.Done()
+	for j := range p.jobs {
+		if j.ctx.Err() == nil {
+			j.work(j.ctx)
+		}
+		j.done()
+	}
+}
+
+// Submit queues work, waiting while the queue is full, and calls done once the work has
+// run or was skipped because ctx ended first. It fails without queueing when ctx ends
+// before there is room, or with ErrPoolClosed once the pool is closed.
+func (p *WorkerPool) Submit(ctx context.Context, work func(ctx context.Context), done func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	select {
+	case <-p.closed:
+		return ErrPoolClosed
+	default:
+	}
+	select {
+	case p.jobs <- job{ctx: ctx, work: work, done: done}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.closed:
+		return ErrPoolClosed
+	}
+}
+
+// Close stops the workers once the queued jobs are done. Handlers still submitting, which
+// grpc.Server.Stop does not wait for, get ErrPoolClosed.
+func (p *WorkerPool) Close() {
+	p.closeOnce.Do(func() {
+		// wakes the Submits waiting for room before taking the lock they hold
+		close(p.closed)
+		p.mu.Lock()
+		close(p.jobs)
+		p.mu.Unlock()
+	})
+	p.wg.Wait()
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/5_multiplexing/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 	"log"
@@ -14,8 +17,27 @@ const (
 	address = "127.0.0.1:20051"
 )
 
+var (
+	tlsConfig     = tlsutil.ClientFlags()
+	serverAddress = endpoint.AddressFlag(address)
+	serviceConfig = flag.String("service_config", "3_deadlines/client/service_config.json", "service config with the retry policy per method")
+	helloAddress  = flag.String("hello_address", "", "hello server when it runs apart, e.g. 127.0.0.1:20052 with services_split.json")
+)
+
 func main() {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	flag.Parse()
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	// GetOrder and SearchOrders are retried on Unavailable, the writes are not
+	retryPolicy, err := os.ReadFile(*serviceConfig)
+	if err != nil {
+		log.Fatalf("failed to load service config: %v", err)
+	}
+	conn, err := endpoint.Dial(*serverAddress, transport,
+		grpc.WithDefaultServiceConfig(string(retryPolicy)))
 	if err != nil {
 		log.Fatalf("did not connect :%v", err)
 	}
@@ -30,8 +52,16 @@ func main() {
 	// 将订单服务客户端绑定至从tcp连接中
 	orderClient := pb.NewOrderManagementClient(conn)
 
-	// 将问候服务客户端绑定至从tcp连接中
-	helloClient := pb.NewHelloClient(conn)
+	// 将问候服务客户端绑定至从tcp连接中, 服务拆分部署时另建连接
+	helloConn := conn
+	if *helloAddress != "" && *helloAddress != *serverAddress {
+		helloConn, err = endpoint.Dial(*helloAddress, transport)
+		if err != nil {
+			log.Fatalf("did not connect :%v", err)
+		}
+		defer helloConn.Close()
+	}
+	helloClient := pb.NewHelloClient(helloConn)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -51,4 +81,31 @@ func main() {
 		}
 		log.Printf("Get HelloServer successfully %v", r)
 	}
+
+	{
+		// Chat runs on the same connection as the calls above
+		stream, err := helloClient.Chat(ctx)
+		if err != nil {
+			log.Fatalf("Could not chat: %v", err)
+		}
+		join := &pb.ChatRequest{Action: &pb.ChatRequest_Join{Join: &pb.JoinRoom{Room: "lobby", User: "client"}}}
+		if err := stream.Send(join); err != nil {
+			log.Fatalf("Could not join the lobby: %v", err)
+		}
+		if err := stream.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Text{Text: "Nice to meet you"}}); err != nil {
+			log.Fatalf("Could not say hello: %v", err)
+		}
+		// everyone in the room hears the message, the sender included
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				log.Fatalf("Could not receive chat events: %v", err)
+			}
+			log.Printf("Chat %s: %v %s %s", ev.Room, ev.Kind, ev.User, ev.Text)
+			if ev.Kind == pb.ChatEvent_MESSAGE && ev.User == "client" {
+				break
+			}
+		}
+		stream.CloseSend()
+	}
 }
@@ -20,6 +20,10 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type HelloClient interface {
 	SayHello(ctx context.Context, in *wrapperspb.StringValue, opts ...grpc.CallOption) (*wrapperspb.StringValue, error)
+	// Chat joins the room of a join request, leaving the current one, and says the text of a
+	// text request to every member of the current room, the sender included. Text before the
+	// first join fails the stream. Ending the stream leaves the room.
+	Chat(ctx context.Context, opts ...grpc.CallOption) (Hello_ChatClient, error)
 }
 
 type helloClient struct {
@@ -39,11 +43,46 @@ func (c *helloClient) SayHello(ctx context.Context, in *wrapperspb.StringValue,
 	return out, nil
 }
 
+func (c *helloClient) Chat(ctx context.Context, opts ...grpc.CallOption) (Hello_ChatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Hello_ServiceDesc.Streams[0], "/proto.hello/Chat", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &helloChatClient{stream}
+	return x, nil
+}
+
+type Hello_ChatClient interface {
+	Send(*ChatRequest) error
+	Recv() (*ChatEvent, error)
+	grpc.ClientStream
+}
+
+type helloChatClient struct {
+	grpc.ClientStream
+}
+
+func (x *helloChatClient) Send(m *ChatRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *helloChatClient) Recv() (*ChatEvent, error) {
+	m := new(ChatEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // HelloServer is the server API for Hello service.
 // All implementations must embed UnimplementedHelloServer
 // for forward compatibility
 type HelloServer interface {
 	SayHello(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+	// Chat joins the room of a join request, leaving the current one, and says the text of a
+	// text request to every member of the current room, the sender included. Text before the
+	// first join fails the stream. Ending the stream leaves the room.
+	Chat(Hello_ChatServer) error
 	mustEmbedUnimplementedHelloServer()
 }
 
@@ -54,6 +93,9 @@ type UnimplementedHelloServer struct {
 func (UnimplementedHelloServer) SayHello(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SayHello not implemented")
 }
+func (UnimplementedHelloServer) Chat(Hello_ChatServer) error {
+	return status.Errorf(codes.Unimplemented, "method Chat not implemented")
+}
 func (UnimplementedHelloServer) mustEmbedUnimplementedHelloServer() {}
 
 // UnsafeHelloServer may be embedded to opt out of forward compatibility for this service.
@@ -85,6 +127,32 @@ func _Hello_SayHello_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Hello_Chat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HelloServer).Chat(&helloChatServer{stream})
+}
+
+type Hello_ChatServer interface {
+	Send(*ChatEvent) error
+	Recv() (*ChatRequest, error)
+	grpc.ServerStream
+}
+
+type helloChatServer struct {
+	grpc.ServerStream
+}
+
+func (x *helloChatServer) Send(m *ChatEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *helloChatServer) Recv() (*ChatRequest, error) {
+	m := new(ChatRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Hello_ServiceDesc is the grpc.ServiceDesc for Hello service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -97,6 +165,13 @@ var Hello_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _Hello_SayHello_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Chat",
+			Handler:       _Hello_Chat_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "hello.proto",
 }
@@ -1,35 +1,97 @@
 package main
 
 import (
-	pb "github.com/kekeee-shine/grpc_training/5_multiplexing/proto"
+	"flag"
 	svc "github.com/kekeee-shine/grpc_training/5_multiplexing/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"github.com/kekeee-shine/grpc_training/pkg/shutdown"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
-const (
-	address = "127.0.0.1"
-	port    = ":20051"
+// probeInterval is how often the services that can check themselves are probed.
+const probeInterval = time.Second * 10
+
+// ListenerConfig serves the named services on one address, in any form endpoint.Listen takes.
+type ListenerConfig struct {
+	Address  string   `json:"address"`
+	Services []string `json:"services"`
+}
+
+// ServicesConfig decides which services the binary runs, and where: all on one listener,
+// or each deployment on its own, see services.json and services_split.json.
+type ServicesConfig struct {
+	Listeners []ListenerConfig `json:"listeners"`
+}
+
+func loadServicesConfig(path string) (ServicesConfig, error) {
+	config := ServicesConfig{}
+	err := jsonconfig.Load(path, &config)
+	return config, err
+}
+
+var (
+	tlsConfig      = tlsutil.ServerFlags()
+	shutdownConfig = shutdown.Flags()
+	servicesFile   = flag.String("services", "5_multiplexing/server/services.json", "services to run on every listener")
 )
 
 func main() {
-	// listen the tcp port
-	lis, err := net.Listen("tcp", port)
+	flag.Parse()
+
+	creds, err := tlsConfig.ServerOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	config, err := loadServicesConfig(*servicesFile)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		log.Fatalf("failed to load services config: %v", err)
+	}
+	if len(config.Listeners) == 0 {
+		log.Fatalf("no listeners in %s, registered services are %v", *servicesFile, svc.Names())
 	}
 
-	s := grpc.NewServer()
+	var wg sync.WaitGroup
+	for _, listener := range config.Listeners {
+		// listen the tcp port
+		lis, err := endpoint.Listen(listener.Address)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+
+		s := grpc.NewServer(creds)
 
-	// 在gRPC orderMgtServer上注册订单管理服务
-	pb.RegisterOrderManagementServer(s, svc.NewOrderServer())
+		// 在gRPC Server上注册配置中的服务
+		serviceNames, err := svc.RegisterServices(s, listener.Services)
+		if err != nil {
+			log.Fatalf("failed to register services on %s: %v", listener.Address, err)
+		}
 
-	// 在gRPC HelloServer上注册问候服务
-	pb.RegisterHelloServer(s, svc.NewHelloServer())
+		// health reports SERVING for every service until the server shuts down, or until the
+		// probe of a service fails
+		hs := healthcheck.Register(s)
+		for service, probe := range svc.Probes(listener.Services) {
+			go healthcheck.Monitor(hs, service, probe, probeInterval, nil)
+		}
+		// reflection lets cmd/grpcall and other tools call the services without their .proto files
+		reflection.Register(s)
 
-	log.Printf("Starting gRPC listener on port " + port)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+		log.Printf("Starting gRPC listener on %s with %v", listener.Address, serviceNames)
+		wg.Add(1)
+		// every listener gets the signal and drains on its own
+		go func(s *grpc.Server, lis net.Listener, hs *healthcheck.Server) {
+			defer wg.Done()
+			if err := shutdownConfig.Serve(s, lis, hs); err != nil {
+				log.Fatalf("failed to serve: %v", err)
+			}
+		}(s, lis, hs)
 	}
+	wg.Wait()
 }
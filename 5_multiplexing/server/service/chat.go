@@ -0,0 +1,215 @@
+package service
+
+import (
+	"fmt"
+	pb "github.com/kekeee-shine/grpc_training/5_multiplexing/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"sync"
+)
+
+// chatBuffer is how many events a member may fall behind before it is dropped from its room,
+// so that one slow consumer cannot hold up the room or grow the server's memory.
+const chatBuffer = 128
+
+// chatMember is one Chat stream. Its room, user and gone are guarded by the hub's lock.
+type chatMember struct {
+	room string
+	user string
+	// gone is set once the stream ended, so a join still in flight cannot put it back
+	gone   bool
+	events chan *pb.ChatEvent
+	// dropped is closed once the member fell too far behind
+	dropped chan struct{}
+}
+
+// chatHub fans the events of a room out to its members.
+type chatHub struct {
+	mu    sync.Mutex
+	rooms map[string]map[*chatMember]struct{}
+}
+
+func newChatHub() *chatHub {
+	return &chatHub{rooms: make(map[string]map[*chatMember]struct{})}
+}
+
+// join moves m to room, leaving the room it is in.
+func (h *chatHub) join(m *chatMember, room, user string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m.gone {
+		return
+	}
+	if m.room != "" {
+		h.removeLocked(m, "")
+	}
+	m.room, m.user = room, user
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*chatMember]struct{})
+	}
+	h.rooms[room][m] = struct{}{}
+	h.broadcastLocked(room, &pb.ChatEvent{Kind: pb.ChatEvent_JOINED, Room: room, User: user})
+}
+
+// say sends text from m to its room.
+func (h *chatHub) say(m *chatMember, text string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m.room == "" {
+		return status.Error(codes.FailedPrecondition, "join a room first")
+	}
+	h.broadcastLocked(m.room, &pb.ChatEvent{Kind: pb.ChatEvent_MESSAGE, Room: m.room, User: m.user, Text: text})
+	return nil
+}
+
+// leave takes m out of its room, if it is in one, for good.
+func (h *chatHub) leave(m *chatMember) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m.gone = true
+	if m.room != "" {
+		h.removeLocked(m, "")
+	}
+}
+
+func (h *chatHub) removeLocked(m *chatMember, reason string) {
+	room := m.room
+	delete(h.rooms[room], m)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+	m.room = ""
+	h.broadcastLocked(room, &pb.ChatEvent{Kind: pb.ChatEvent_LEFT, Room: room, User: m.user, Text: reason})
+}
+
+// broadcastLocked queues ev for every member of room without waiting for any of them. A
+// member whose queue is full is dropped from the room, which the others hear about.
+func (h *chatHub) broadcastLocked(room string, ev *pb.ChatEvent) {
+	var slow []*chatMember
+	for m := range h.rooms[room] {
+		select {
+		case m.events <- ev:
+		default:
+			slow = append(slow, m)
+		}
+	}
+	for _, m := range slow {
+		// a member dropped by an earlier broadcast of this loop is gone already
+		if m.room != room {
+			continue
+		}
+		log.Printf("chat: dropping %s from %s, %d events behind", m.user, room, chatBuffer)
+		// the stream is about to end: no later join may put it back and drop it again
+		m.gone = true
+		close(m.dropped)
+		h.removeLocked(m, fmt.Sprintf("too slow, %d events behind", chatBuffer))
+	}
+}
+
+// Chat implements proto.HelloServer
+func (h HelloServer) Chat(stream pb.Hello_ChatServer) error {
+	m := &chatMember{events: make(chan *pb.ChatEvent, chatBuffer), dropped: make(chan struct{})}
+	defer h.hub.leave(m)
+
+	// requests are read on their own goroutine, so a member that stops reading its events
+	// can still be dropped while it is talking. Recv is all it does: the requests are
+	// handled here, and its last Recv returns as soon as the handler did.
+	done := make(chan struct{})
+	defer close(done)
+	requests := make(chan *pb.ChatRequest)
+	received := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				received <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// events are sent on their own goroutine as well, so that requests are still read
+	// while Send blocks on a client that does not read. The handler waits for it before
+	// returning, Send must not be called once the handler returned.
+	stop := make(chan struct{})
+	sent := make(chan error, 1)
+	sending := sync.WaitGroup{}
+	sending.Add(1)
+	go func() {
+		defer sending.Done()
+		for {
+			select {
+			case ev, ok := <-m.events:
+				if !ok {
+					sent <- nil
+					return
+				}
+				if err := stream.Send(ev); err != nil {
+					sent <- err
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	// stopSending ends the sender after the event in flight, which a dropped member's
+	// client still gets once it reads again or goes away.
+	stopSending := func() {
+		close(stop)
+		sending.Wait()
+	}
+
+	for {
+		select {
+		case req := <-requests:
+			if err := h.handle(m, req); err != nil {
+				stopSending()
+				return err
+			}
+		case err := <-received:
+			if err != io.EOF {
+				stopSending()
+				return err
+			}
+			// the client is done talking, which leaves the room: nothing is queued for the
+			// member after that, so the events already queued are flushed and the sender ends
+			h.hub.leave(m)
+			close(m.events)
+			sending.Wait()
+			return <-sent
+		case err := <-sent:
+			sending.Wait()
+			return err
+		case <-m.dropped:
+			stopSending()
+			return status.Errorf(codes.ResourceExhausted, "dropped from the room: more than %d events behind", chatBuffer)
+		case <-stream.Context().Done():
+			stopSending()
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+// handle applies one request of m to the hub.
+func (h HelloServer) handle(m *chatMember, req *pb.ChatRequest) error {
+	switch action := req.Action.(type) {
+	case *pb.ChatRequest_Join:
+		if action.Join.Room == "" || action.Join.User == "" {
+			return status.Error(codes.InvalidArgument, "join needs a room and a user")
+		}
+		h.hub.join(m, action.Join.Room, action.Join.User)
+		return nil
+	case *pb.ChatRequest_Text:
+		return h.hub.say(m, action.Text)
+	default:
+		return status.Error(codes.InvalidArgument, "request has neither join nor text")
+	}
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/kekeee-shine/grpc_training/5_multiplexing/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// members of the busy room, and the messages every one of them says
+	members  = 50
+	messages = 20
+	// streamWindow is the flow control window of a stream. Fixing it keeps the client from
+	// growing it, so a member that stops reading blocks the server after a known amount.
+	streamWindow = 1 << 16
+)
+
+// dialChat serves the order and hello services in process and returns one connection to
+// them, which every stream and call of a test shares.
+func dialChat(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	// the handlers log every message
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	if _, err := RegisterServices(s, []string{"OrderManagement", "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithInitialWindowSize(streamWindow), grpc.WithInitialConnWindowSize(streamWindow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestChatBusyRoom has every member say its messages, one after the other once it heard the
+// last one back, while all the others talk as well and order calls run on the same connection.
+func TestChatBusyRoom(t *testing.T) {
+	conn := dialChat(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	orders := make(chan error, 1)
+	stopOrders := make(chan struct{})
+	go func() { orders <- callOrders(ctx, pb.NewOrderManagementClient(conn), stopOrders) }()
+	defer func() {
+		close(stopOrders)
+		if err := <-orders; err != nil {
+			t.Errorf("order calls failed next to the chat: %v", err)
+		}
+	}()
+
+	client := pb.NewHelloClient(conn)
+	joined := sync.WaitGroup{}
+	joined.Add(members)
+	start := make(chan struct{})
+	errs := make(chan error, members)
+	for i := 0; i < members; i++ {
+		go func(user string) {
+			errs <- member(ctx, client, user, &joined, start)
+		}("member-" + strconv.Itoa(i))
+	}
+	allJoined := make(chan struct{})
+	go func() {
+		joined.Wait()
+		close(allJoined)
+	}()
+	select {
+	case <-allJoined:
+	case err := <-errs:
+		t.Fatalf("not every member joined: %v", err)
+	case <-ctx.Done():
+		t.Fatalf("not every member joined: %v", ctx.Err())
+	}
+	close(start)
+	for i := 0; i < members; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// callOrders keeps calling getOrder until stop is closed.
+func callOrders(ctx context.Context, client pb.OrderManagementClient, stop chan struct{}) error {
+	calls := 0
+	for {
+		select {
+		case <-stop:
+			if calls == 0 {
+				return fmt.Errorf("no order call finished during the chat")
+			}
+			return nil
+		default:
+		}
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		_, err := client.GetOrder(callCtx, &wrapper.StringValue{Value: "101"})
+		cancel()
+		if err != nil {
+			return err
+		}
+		calls++
+	}
+}
+
+func member(ctx context.Context, client pb.HelloClient, user string, joined *sync.WaitGroup, start chan struct{}) error {
+	stream, err := client.Chat(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %v", user, err)
+	}
+	if err := stream.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Join{Join: &pb.JoinRoom{Room: "busy", User: user}}}); err != nil {
+		return fmt.Errorf("%s: failed to join: %v", user, err)
+	}
+
+	// echo carries the messages of user as the room hands them back
+	echo := make(chan string, messages)
+	received := make(chan error, 1)
+	go func() {
+		last := make(map[string]int)
+		heard := 0
+		for heard < members*messages {
+			ev, err := stream.Recv()
+			if err != nil {
+				received <- fmt.Errorf("%s: after %d messages: %v", user, heard, err)
+				return
+			}
+			switch ev.Kind {
+			case pb.ChatEvent_JOINED:
+				if ev.User == user {
+					joined.Done()
+				}
+			case pb.ChatEvent_LEFT:
+				// the members that heard everything leave, none may be dropped
+				if ev.Text != "" {
+					received <- fmt.Errorf("%s: %s was dropped from the room: %s", user, ev.User, ev.Text)
+					return
+				}
+			case pb.ChatEvent_MESSAGE:
+				sender, n := parse(ev.Text)
+				if n != last[sender]+1 {
+					received <- fmt.Errorf("%s: heard message %d of %s after %d", user, n, sender, last[sender])
+					return
+				}
+				last[sender] = n
+				heard++
+				if sender == user {
+					echo <- ev.Text
+				}
+			}
+		}
+		received <- nil
+	}()
+
+	<-start
+	for n := 1; n <= messages; n++ {
+		if err := stream.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Text{Text: fmt.Sprintf("%s %d", user, n)}}); err != nil {
+			return fmt.Errorf("%s: failed to say message %d: %v", user, n, err)
+		}
+		select {
+		case <-echo:
+		case err := <-received:
+			return err
+		}
+	}
+	if err := <-received; err != nil {
+		return err
+	}
+	return stream.CloseSend()
+}
+
+func parse(text string) (string, int) {
+	sender, n, _ := strings.Cut(text, " ")
+	i, _ := strconv.Atoi(n)
+	return sender, i
+}
+
+// TestChatDropsSlowConsumer has one member stop reading while another keeps talking, and
+// checks that the silent one is dropped and the talker is not held up.
+func TestChatDropsSlowConsumer(t *testing.T) {
+	client := pb.NewHelloClient(dialChat(t))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	slow, err := client.Chat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := slow.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Join{Join: &pb.JoinRoom{Room: "slow", User: "slow"}}}); err != nil {
+		t.Fatalf("slow: failed to join: %v", err)
+	}
+	talker, err := client.Chat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := talker.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Join{Join: &pb.JoinRoom{Room: "slow", User: "talker"}}}); err != nil {
+		t.Fatalf("talker: failed to join: %v", err)
+	}
+
+	// a kilobyte per message fills the window of the silent member quickly
+	padding := strings.Repeat(".", 1024)
+	said, heard := 0, 0
+	dropped := false
+	for !dropped || heard < said {
+		if !dropped {
+			said++
+			if said > 10000 {
+				t.Fatalf("slow was not dropped after %d messages", said-1)
+			}
+			if err := talker.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Text{Text: padding}}); err != nil {
+				t.Fatalf("talker: failed to say message %d: %v", said, err)
+			}
+		}
+		// wait for the echo, so the talker never falls behind itself
+		for {
+			ev, err := talker.Recv()
+			if err != nil {
+				t.Fatalf("talker: %v", err)
+			}
+			if ev.Kind == pb.ChatEvent_LEFT && ev.User == "slow" {
+				dropped = true
+			}
+			if ev.Kind == pb.ChatEvent_MESSAGE {
+				heard++
+				break
+			}
+		}
+	}
+	talker.CloseSend()
+
+	// the silent member gets what was on its way, then the reason it was dropped
+	for {
+		_, err := slow.Recv()
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("slow: stream ended with %v, want ResourceExhausted", err)
+		}
+		return
+	}
+}
+
+func TestChatDroppedMemberCannotRejoin(t *testing.T) {
+	h := newChatHub()
+	// no room for a single event: the first broadcast drops the member
+	m := &chatMember{events: make(chan *pb.ChatEvent), dropped: make(chan struct{})}
+	other := &chatMember{events: make(chan *pb.ChatEvent, chatBuffer), dropped: make(chan struct{})}
+	h.join(other, "room", "other")
+	h.join(m, "room", "member")
+	select {
+	case <-m.dropped:
+	default:
+		t.Fatal("member without room for events was not dropped")
+	}
+
+	// a join still in flight when the member was dropped must not put it back, or the
+	// next broadcast drops it again and closes dropped twice
+	h.join(m, "room", "member")
+	if err := h.say(other, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.rooms["room"][m]; ok {
+		t.Error("dropped member rejoined the room")
+	}
+}
+
+// TestChatFlushesQueuedEvents has one member stop reading until more events are queued for
+// it than its window holds, then finish its stream, and checks that it still gets them all.
+func TestChatFlushesQueuedEvents(t *testing.T) {
+	client := pb.NewHelloClient(dialChat(t))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	quiet, err := client.Chat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := quiet.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Join{Join: &pb.JoinRoom{Room: "flush", User: "quiet"}}}); err != nil {
+		t.Fatalf("quiet: failed to join: %v", err)
+	}
+	if ev, err := quiet.Recv(); err != nil || ev.Kind != pb.ChatEvent_JOINED {
+		t.Fatalf("quiet: joined with %v, %v", ev, err)
+	}
+	talker, err := client.Chat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := talker.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Join{Join: &pb.JoinRoom{Room: "flush", User: "talker"}}}); err != nil {
+		t.Fatalf("talker: failed to join: %v", err)
+	}
+
+	// four times the window of the quiet member, but half its queue
+	padding := strings.Repeat(".", 4096)
+	said := 4 * streamWindow / len(padding)
+	for n := 1; n <= said; n++ {
+		if err := talker.Send(&pb.ChatRequest{Action: &pb.ChatRequest_Text{Text: fmt.Sprintf("%d%s", n, padding)}}); err != nil {
+			t.Fatalf("talker: failed to say message %d: %v", n, err)
+		}
+		// the echo means the message was queued for the quiet member as well
+		for {
+			ev, err := talker.Recv()
+			if err != nil {
+				t.Fatalf("talker: %v", err)
+			}
+			if ev.Kind == pb.ChatEvent_MESSAGE {
+				break
+			}
+		}
+	}
+	talker.CloseSend()
+
+	if err := quiet.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	heard := 0
+	for {
+		ev, err := quiet.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("quiet: stream ended with %v after %d messages", err, heard)
+		}
+		if ev.Kind == pb.ChatEvent_MESSAGE {
+			heard++
+			if want := strconv.Itoa(heard) + padding; ev.Text != want {
+				t.Fatalf("quiet: message %d is %.10q, want %.10q", heard, ev.Text, want)
+			}
+		}
+	}
+	if heard != said {
+		t.Errorf("quiet heard %d of %d messages", heard, said)
+	}
+}
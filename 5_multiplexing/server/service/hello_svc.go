@@ -9,12 +9,18 @@ import (
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+func init() {
+	Register("hello", &pb.Hello_ServiceDesc, func() interface{} { return NewHelloServer() })
+}
+
 type HelloServer struct {
 	pb.HelloServer
+	// hub holds the chat rooms, shared by every Chat stream
+	hub *chatHub
 }
 
 func NewHelloServer() *HelloServer {
-	return &HelloServer{}
+	return &HelloServer{hub: newChatHub()}
 }
 
 func (h HelloServer) SayHello(ctx context.Context, value *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
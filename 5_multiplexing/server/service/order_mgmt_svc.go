@@ -9,9 +9,15 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 )
 
+func init() {
+	Register("OrderManagement", &pb.OrderManagement_ServiceDesc, func() interface{} { return NewOrderServer() })
+}
+
 type OrderServer struct {
+	mu       *sync.RWMutex
 	orderMap map[string]*pb.Order
 	pb.OrderManagementServer
 }
@@ -23,13 +29,26 @@ func NewOrderServer() *OrderServer {
 	orderMap["103"] = &pb.Order{Id: "103", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00}
 	orderMap["104"] = &pb.Order{Id: "104", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00}
 	orderMap["105"] = &pb.Order{Id: "105", Items: []string{"Amazon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 30.00}
-	return &OrderServer{orderMap: orderMap}
+	return &OrderServer{mu: &sync.RWMutex{}, orderMap: orderMap}
+}
+
+// Check implements Checker: without orders in its store the service cannot answer anything.
+func (s OrderServer) Check(ctx context.Context) error {
+	s.mu.RLock()
+	empty := len(s.orderMap) == 0
+	s.mu.RUnlock()
+	if empty {
+		return status.Error(codes.Unavailable, "order store is empty")
+	}
+	return nil
 }
 
 //	GetOrder implements proto.OrderManagementServer
 func (s OrderServer) GetOrder(ctx context.Context, value *wrapper.StringValue) (*pb.Order, error) {
 	log.Println("Handle GetOrder request : ", value.GetValue())
+	s.mu.RLock()
 	order, exists := s.orderMap[value.Value]
+	s.mu.RUnlock()
 	if exists {
 		return order, status.New(codes.OK, "").Err()
 	}
@@ -39,19 +58,27 @@ func (s OrderServer) GetOrder(ctx context.Context, value *wrapper.StringValue) (
 //	SearchOrders implements proto.OrderManagementServer
 func (s OrderServer) SearchOrders(value *wrapper.StringValue, server pb.OrderManagement_SearchOrdersServer) error {
 	log.Println("Handle SearchOrders request : ", value.GetValue())
+	s.mu.RLock()
+	matches := make(map[string]*pb.Order)
 	for key, order := range s.orderMap {
 		for _, itemStr := range order.Items {
 			if strings.Contains(itemStr, value.Value) {
-				// Send the matching orders in a stream
-				log.Print("Matching Order Found : "+key, " -> Writing Order to the stream ... ")
-				err := server.Send(order)
-				if err != nil {
-					return err
-				}
+				matches[key] = order
 				break
 			}
 		}
 	}
+	s.mu.RUnlock()
+
+	// the lock is not held while sending, a slow client would hold up every writer
+	for key, order := range matches {
+		// Send the matching orders in a stream
+		log.Print("Matching Order Found : "+key, " -> Writing Order to the stream ... ")
+		err := server.Send(order)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -68,7 +95,9 @@ func (s OrderServer) UpdateOrders(server pb.OrderManagement_UpdateOrdersServer)
 		}
 		// Update order
 
+		s.mu.Lock()
 		s.orderMap[order.Id] = order
+		s.mu.Unlock()
 
 		ordersStr += order.Id + ", "
 	}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"sort"
+	"sync"
+)
+
+// registration is a service known to the registry. Its implementation is created on first
+// use and shared by every listener that serves it, so they all see the same state.
+type registration struct {
+	desc    *grpc.ServiceDesc
+	factory func() interface{}
+	once    sync.Once
+	impl    interface{}
+}
+
+func (r *registration) instance() interface{} {
+	r.once.Do(func() { r.impl = r.factory() })
+	return r.impl
+}
+
+// Checker is implemented by the services that can tell whether they are able to serve,
+// e.g. whether their store is reachable.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*registration)
+)
+
+// Register makes a service available under name. Services call it from init.
+func Register(name string, desc *grpc.ServiceDesc, factory func() interface{}) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("service: Register called twice for " + name)
+	}
+	registry[name] = &registration{desc: desc, factory: factory}
+}
+
+// Names lists the registered services.
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return registeredNames()
+}
+
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterServices registers the named services on s and returns their full gRPC service
+// names, e.g. proto.OrderManagement for OrderManagement.
+func RegisterServices(s *grpc.Server, names []string) ([]string, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	serviceNames := make([]string, 0, len(names))
+	for _, name := range names {
+		r, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown service %q, registered are %v", name, registeredNames())
+		}
+		s.RegisterService(r.desc, r.instance())
+		serviceNames = append(serviceNames, r.desc.ServiceName)
+	}
+	return serviceNames, nil
+}
+
+// Probes returns the health checks of the named services that implement Checker, by full
+// gRPC service name.
+func Probes(names []string) map[string]func(ctx context.Context) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	probes := make(map[string]func(ctx context.Context) error)
+	for _, name := range names {
+		r, ok := registry[name]
+		if !ok {
+			continue
+		}
+		if checker, ok := r.instance().(Checker); ok {
+			probes[r.desc.ServiceName] = checker.Check
+		}
+	}
+	return probes
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/6_metadata/proto"
 	svc "github.com/kekeee-shine/grpc_training/6_metadata/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/deadline"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/fault"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"github.com/kekeee-shine/grpc_training/pkg/shutdown"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"log"
-	"net"
 )
 
 const (
@@ -13,19 +20,54 @@ const (
 	port    = ":20051"
 )
 
+var (
+	tlsConfig      = tlsutil.ServerFlags()
+	listenAddress  = endpoint.ListenFlag(port)
+	shutdownConfig = shutdown.Flags()
+	faultFile      = flag.String("faults", "6_metadata/server/faults.json", "latency and errors to inject per method")
+	deadlineFile   = flag.String("deadlines", "6_metadata/server/deadlines.json", "default and maximum deadline per method")
+)
+
 func main() {
-	// listen the tcp port
-	lis, err := net.Listen("tcp", port)
+	flag.Parse()
+
+	creds, err := tlsConfig.ServerOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+
+	faultConfig, err := fault.LoadConfig(*faultFile)
+	if err != nil {
+		log.Fatalf("failed to load fault config: %v", err)
+	}
+	faults := fault.NewInjector(faultConfig)
+
+	deadlineConfig, err := deadline.LoadConfig(*deadlineFile)
+	if err != nil {
+		log.Fatalf("failed to load deadline config: %v", err)
+	}
+	deadlines := deadline.NewServerDeadlines(deadlineConfig)
+
+	// listen on tcp, a unix socket or in process
+	lis, err := endpoint.Listen(*listenAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
 	//s := grpc.NewServer(grpc.UnaryInterceptor(interceptors.OrderUnaryServerInterceptor1),
 	//	grpc.ChainUnaryInterceptor(interceptors.OrderUnaryServerInterceptor2, interceptors.OrderUnaryServerInterceptor3))
-	s := grpc.NewServer()
+	s := grpc.NewServer(creds,
+		grpc.ChainUnaryInterceptor(deadlines.UnaryServerInterceptor(), faults.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(deadlines.StreamServerInterceptor(), faults.StreamServerInterceptor()))
 	pb.RegisterOrderManagementServer(s, svc.NewServer())
-	log.Printf("Starting gRPC listener on port " + port)
-	if err := s.Serve(lis); err != nil {
+
+	// health reports SERVING for every service until the server shuts down
+	hs := healthcheck.Register(s)
+	// reflection lets cmd/grpcall and other tools call the services without their .proto files
+	reflection.Register(s)
+
+	log.Printf("Starting gRPC listener on %s", *listenAddress)
+	if err := shutdownConfig.Serve(s, lis, hs); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
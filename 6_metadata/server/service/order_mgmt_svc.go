@@ -32,8 +32,6 @@ func NewServer() *Server {
 
 //	GetOrder implements proto.OrderManagementServer
 func (s Server) GetOrder(ctx context.Context, value *wrapper.StringValue) (*pb.Order, error) {
-
-	time.Sleep(time.Second * 5)
 	// reading from context
 	if mdCtx, ok := metadata.FromIncomingContext(ctx); ok {
 		if cTimeMap, ok := mdCtx["client_time"]; ok {
@@ -9,7 +9,6 @@ import (
 	"io"
 	"log"
 	"strings"
-	"time"
 )
 
 type Server struct {
@@ -29,8 +28,6 @@ func NewServer() *Server {
 
 //	GetOrder implements proto.OrderManagementServer
 func (s Server) GetOrder(ctx context.Context, value *wrapper.StringValue) (*pb.Order, error) {
-	time.Sleep(time.Second * 8)
-
 	if ctx.Err() == context.DeadlineExceeded {
 		log.Printf("RPC has reached deadline exceeded state : %s", ctx.Err())
 		return nil, ctx.Err()
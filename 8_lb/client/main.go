@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	pb "github.com/kekeee-shine/grpc_training/7_resolver/proto"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/resolver"
 	wrapper "google.golang.org/protobuf/types/known/wrapperspb"
@@ -19,8 +21,25 @@ const (
 	address = "127.0.0.1:20051"
 )
 
+var (
+	tlsConfig     = tlsutil.ClientFlags()
+	serviceConfig = flag.String("service_config", "3_deadlines/client/service_config.json", "service config with the retry policy per method")
+)
+
 func main() {
-	conn, err := grpc.Dial(fmt.Sprintf("%s:///%s", myScheme, myServiceName), grpc.WithInsecure())
+	flag.Parse()
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	// GetOrder and SearchOrders are retried on Unavailable, the writes are not
+	retryPolicy, err := os.ReadFile(*serviceConfig)
+	if err != nil {
+		log.Fatalf("failed to load service config: %v", err)
+	}
+	conn, err := grpc.Dial(fmt.Sprintf("%s:///%s", myScheme, myServiceName), transport,
+		grpc.WithDefaultServiceConfig(string(retryPolicy)))
 	// "example:///kekeee.com"
 	if err != nil {
 		log.Fatalf("did not connect :%v", err)
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"flag"
 	pb "github.com/kekeee-shine/grpc_training/7_resolver/proto"
 	svc "github.com/kekeee-shine/grpc_training/7_resolver/server/service"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/fault"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"github.com/kekeee-shine/grpc_training/pkg/shutdown"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"log"
-	"net"
 )
 
 const (
@@ -13,16 +19,44 @@ const (
 	port    = ":20051"
 )
 
+var (
+	tlsConfig      = tlsutil.ServerFlags()
+	listenAddress  = endpoint.ListenFlag(port)
+	shutdownConfig = shutdown.Flags()
+	faultFile      = flag.String("faults", "8_lb/server/faults.json", "latency and errors to inject per method")
+)
+
 func main() {
-	// listen the tcp port
-	lis, err := net.Listen("tcp", port)
+	flag.Parse()
+
+	creds, err := tlsConfig.ServerOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+
+	faultConfig, err := fault.LoadConfig(*faultFile)
+	if err != nil {
+		log.Fatalf("failed to load fault config: %v", err)
+	}
+	faults := fault.NewInjector(faultConfig)
+
+	// listen on tcp, a unix socket or in process
+	lis, err := endpoint.Listen(*listenAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(creds,
+		grpc.UnaryInterceptor(faults.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(faults.StreamServerInterceptor()))
 	pb.RegisterOrderManagementServer(s, svc.NewServer())
-	log.Printf("Starting gRPC listener on port " + port)
-	if err := s.Serve(lis); err != nil {
+
+	// health reports SERVING for every service until the server shuts down
+	hs := healthcheck.Register(s)
+	// reflection lets cmd/grpcall and other tools call the services without their .proto files
+	reflection.Register(s)
+
+	log.Printf("Starting gRPC listener on %s", *listenAddress)
+	if err := shutdownConfig.Serve(s, lis, hs); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
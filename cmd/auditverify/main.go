@@ -0,0 +1,25 @@
+// auditverify checks the hash chain of an audit log written by the audit interceptor and
+// prints the hash of its last record, to compare against a copy kept elsewhere.
+//
+//	go run ./cmd/auditverify -log audit.log
+package main
+
+import (
+	"flag"
+	"github.com/kekeee-shine/grpc_training/pkg/audit"
+	"log"
+	"os"
+)
+
+var path = flag.String("log", "audit.log", "audit log to verify")
+
+func main() {
+	flag.Parse()
+
+	last, err := audit.Verify(*path)
+	if err != nil {
+		log.Printf("audit log %s is NOT intact: %v", *path, err)
+		os.Exit(1)
+	}
+	log.Printf("audit log %s is intact: %d records, last hash %s", *path, last.Seq, last.Hash)
+}
@@ -0,0 +1,37 @@
+// gencerts writes a server and a client certificate signed by a development CA, which it
+// creates on the first run.
+//
+//	go run ./cmd/gencerts -out certs -client_ou reader
+//	go run ./2_interceptors/server -tls_cert certs/server.pem -tls_key certs/server-key.pem -tls_client_ca certs/ca.pem
+//	go run ./2_interceptors/client -tls_ca certs/ca.pem -tls_cert certs/client.pem -tls_key certs/client-key.pem
+//
+// Running it again while the server and clients are up rotates their certificates without a
+// restart. The CA stays, so the running clients keep trusting the server; remove ca.pem and
+// ca-key.pem to start over with a new CA, and restart everything afterwards.
+package main
+
+import (
+	"flag"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
+	"log"
+	"strings"
+)
+
+var (
+	out      = flag.String("out", "certs", "output directory")
+	clientCN = flag.String("client_cn", "demo-client", "common name of the client certificate")
+	clientOU = flag.String("client_ou", "reader", "comma separated organizational units (roles) of the client certificate")
+)
+
+func main() {
+	flag.Parse()
+
+	var ous []string
+	if *clientOU != "" {
+		ous = strings.Split(*clientOU, ",")
+	}
+	if err := tlsutil.WriteDevCerts(*out, *clientCN, ous...); err != nil {
+		log.Fatalf("failed to write certificates: %v", err)
+	}
+	log.Printf("certificates written to %s", *out)
+}
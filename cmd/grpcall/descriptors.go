@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// reflectionClient asks a server for its services and the descriptors of their .proto
+// files over the server reflection service.
+type reflectionClient struct {
+	stream reflectionpb.ServerReflection_ServerReflectionInfoClient
+	// files are the descriptors received so far, by file name
+	files map[string]*descriptorpb.FileDescriptorProto
+}
+
+func newReflectionClient(ctx context.Context, conn *grpc.ClientConn) (*reflectionClient, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &reflectionClient{stream: stream, files: make(map[string]*descriptorpb.FileDescriptorProto)}, nil
+}
+
+func (c *reflectionClient) close() {
+	c.stream.CloseSend()
+}
+
+func (c *reflectionClient) request(req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	if err := c.stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("reflection: %s (code %d)", e.ErrorMessage, e.ErrorCode)
+	}
+	return resp, nil
+}
+
+// listServices returns the full names of the services of the server.
+func (c *reflectionClient) listServices() ([]string, error) {
+	resp, err := c.request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names = append(names, s.Name)
+	}
+	return names, nil
+}
+
+// resolveService returns the descriptor of the service, with the files it depends on
+// fetched from the server as well.
+func (c *reflectionClient) resolveService(name string) (protoreflect.ServiceDescriptor, *protoregistry.Files, error) {
+	resp, err := c.request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: name},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.add(resp.GetFileDescriptorResponse().GetFileDescriptorProto()); err != nil {
+		return nil, nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range c.files {
+		set.File = append(set.File, fd)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, nil, err
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a service", name)
+	}
+	return service, files, nil
+}
+
+// add keeps the serialized descriptors and fetches the dependencies the server left out.
+// A server may send the dependencies of a file along with it, but needn't.
+func (c *reflectionClient) add(raw [][]byte) error {
+	var imports []string
+	for _, b := range raw {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fd); err != nil {
+			return err
+		}
+		if _, ok := c.files[fd.GetName()]; ok {
+			continue
+		}
+		c.files[fd.GetName()] = fd
+		imports = append(imports, fd.Dependency...)
+	}
+	for _, name := range imports {
+		if _, ok := c.files[name]; ok {
+			continue
+		}
+		resp, err := c.request(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.add(resp.GetFileDescriptorResponse().GetFileDescriptorProto()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
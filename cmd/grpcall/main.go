@@ -0,0 +1,256 @@
+// grpcall calls any method of a training server without its generated stubs. It learns the
+// services and their messages from the server reflection service, reads the requests as
+// JSON and prints the responses as JSON.
+//
+//	go run ./cmd/grpcall list
+//	go run ./cmd/grpcall list proto.OrderManagement
+//	go run ./cmd/grpcall -d '"102"' call proto.OrderManagement/getOrder
+//	echo '"102" "104"' | go run ./cmd/grpcall -d @ call proto.OrderManagement/processOrders
+//
+// Unary and server streaming methods take one request, client and bidirectional streaming
+// methods any number of them, one JSON value after the other.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// headers collects the repeated -H flags.
+type headers []string
+
+func (h *headers) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headers) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q is not of the form key: value", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+var (
+	tlsConfig = tlsutil.ClientFlags()
+	address   = endpoint.AddressFlag("127.0.0.1:20051")
+	data      = flag.String("d", "", "request message(s) as JSON, @ to read them from stdin, empty for one empty message")
+	timeout   = flag.Duration("timeout", time.Second*30, "deadline of the call, 0 for none")
+	header    headers
+)
+
+func main() {
+	flag.Var(&header, "H", "metadata to send, as key: value, repeatable")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: grpcall [flags] list [service]\n       grpcall [flags] call service/method\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 || args[0] == "list" && len(args) > 2 || args[0] == "call" && len(args) != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	conn, err := endpoint.Dial(*address, transport)
+	if err != nil {
+		log.Fatalf("did not connect :%v", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	for _, h := range header {
+		key, value, _ := strings.Cut(h, ":")
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+
+	rc, err := newReflectionClient(ctx, conn)
+	if err != nil {
+		log.Fatalf("Could not reach the reflection service: %v", err)
+	}
+	defer rc.close()
+
+	switch args[0] {
+	case "list":
+		if len(args) == 1 {
+			err = listServices(rc)
+		} else {
+			err = listMethods(rc, args[1])
+		}
+	case "call":
+		err = call(ctx, conn, rc, args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func listServices(rc *reflectionClient) error {
+	names, err := rc.listServices()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func listMethods(rc *reflectionClient, name string) error {
+	service, _, err := rc.resolveService(name)
+	if err != nil {
+		return err
+	}
+	fmt.Println(service.FullName())
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		m := methods.Get(i)
+		fmt.Printf("  rpc %s(%s%s) returns (%s%s)\n", m.Name(),
+			streamPrefix(m.IsStreamingClient()), m.Input().FullName(),
+			streamPrefix(m.IsStreamingServer()), m.Output().FullName())
+	}
+	return nil
+}
+
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+// call invokes the method, named service/method or service.method, with the requests of -d.
+func call(ctx context.Context, conn *grpc.ClientConn, rc *reflectionClient, name string) error {
+	i := strings.LastIndexAny(name, "/.")
+	if i < 0 {
+		return fmt.Errorf("method %q is not of the form service/method", name)
+	}
+	service, files, err := rc.resolveService(name[:i])
+	if err != nil {
+		return err
+	}
+	method := service.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if method == nil {
+		return fmt.Errorf("service %s has no method %s", service.FullName(), name[i+1:])
+	}
+
+	types := dynamicpb.NewTypes(files)
+	requests, err := readRequests(method.Input(), types)
+	if err != nil {
+		return err
+	}
+	if !method.IsStreamingClient() && len(requests) != 1 {
+		return fmt.Errorf("%s takes exactly one request, got %d", method.Name(), len(requests))
+	}
+	out := protojson.MarshalOptions{Multiline: true, Resolver: types}
+	printResponse := func(m *dynamicpb.Message) error {
+		b, err := out.Marshal(m)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	fullMethod := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
+	if !method.IsStreamingClient() && !method.IsStreamingServer() {
+		resp := dynamicpb.NewMessage(method.Output())
+		if err := conn.Invoke(ctx, fullMethod, requests[0], resp); err != nil {
+			return err
+		}
+		return printResponse(resp)
+	}
+
+	desc := &grpc.StreamDesc{
+		StreamName:    string(method.Name()),
+		ClientStreams: method.IsStreamingClient(),
+		ServerStreams: method.IsStreamingServer(),
+	}
+	stream, err := conn.NewStream(ctx, desc, fullMethod)
+	if err != nil {
+		return err
+	}
+	// the requests go out while the responses come in, so a bidirectional stream that
+	// answers as it goes is printed as it goes
+	go func() {
+		for _, req := range requests {
+			// on failure RecvMsg below reports the status of the stream
+			if err := stream.SendMsg(req); err != nil {
+				return
+			}
+		}
+		stream.CloseSend()
+	}()
+	for {
+		resp := dynamicpb.NewMessage(method.Output())
+		err := stream.RecvMsg(resp)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := printResponse(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// readRequests parses the JSON values of -d into messages of type desc.
+func readRequests(desc protoreflect.MessageDescriptor, types *dynamicpb.Types) ([]*dynamicpb.Message, error) {
+	var input io.Reader
+	switch *data {
+	case "":
+		return []*dynamicpb.Message{dynamicpb.NewMessage(desc)}, nil
+	case "@":
+		input = os.Stdin
+	default:
+		input = strings.NewReader(*data)
+	}
+
+	in := protojson.UnmarshalOptions{Resolver: types}
+	var requests []*dynamicpb.Message
+	dec := json.NewDecoder(input)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request %d: %v", len(requests)+1, err)
+		}
+		m := dynamicpb.NewMessage(desc)
+		if err := in.Unmarshal(raw, m); err != nil {
+			return nil, fmt.Errorf("request %d is not a %s: %v", len(requests)+1, desc.FullName(), err)
+		}
+		requests = append(requests, m)
+	}
+}
@@ -0,0 +1,59 @@
+// healthwatch asks a training server for the health of one of its services, e.g.
+// proto.OrderManagement, basic.ProductInfo or proto.hello, or of the whole server when
+// -service is empty. With -watch it keeps printing every change until the server goes away.
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/kekeee-shine/grpc_training/pkg/endpoint"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"log"
+	"time"
+)
+
+var (
+	tlsConfig = tlsutil.ClientFlags()
+	address   = endpoint.AddressFlag("127.0.0.1:20051")
+	service   = flag.String("service", "", "full name of the service, empty for the whole server")
+	watch     = flag.Bool("watch", false, "print every status change instead of checking once")
+)
+
+func main() {
+	flag.Parse()
+
+	transport, err := tlsConfig.DialOption()
+	if err != nil {
+		log.Fatalf("failed to load tls config: %v", err)
+	}
+	conn, err := endpoint.Dial(*address, transport)
+	if err != nil {
+		log.Fatalf("did not connect :%v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	if !*watch {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: *service})
+		if err != nil {
+			log.Fatalf("Could not check health: %v", err)
+		}
+		log.Printf("%q: %v", *service, resp.Status)
+		return
+	}
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{Service: *service})
+	if err != nil {
+		log.Fatalf("Could not watch health: %v", err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			log.Fatalf("Watch ended: %v", err)
+		}
+		log.Printf("%q: %v", *service, resp.Status)
+	}
+}
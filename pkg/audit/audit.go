@@ -0,0 +1,248 @@
+// Package audit keeps a tamper-evident log of the mutating calls of a server.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kekeee-shine/grpc_training/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Received is the Status of the record of a client stream message, written when the
+// handler receives it. The stream's outcome follows in a record of its own.
+const Received = "RECEIVED"
+
+// Record is one line of the audit log. Hash covers every other field, Prev included,
+// so changing, inserting or removing a record breaks the chain from that record on.
+// The records of one client stream share its Stream ID.
+type Record struct {
+	Seq    int64  `json:"seq"`
+	Time   string `json:"time"`
+	Method string `json:"method"`
+	Caller string `json:"caller"`
+	Stream string `json:"stream,omitempty"`
+	Target string `json:"target,omitempty"`
+	Digest string `json:"digest"`
+	Status string `json:"status"`
+	Prev   string `json:"prev"`
+	Hash   string `json:"hash"`
+}
+
+func (r Record) computeHash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log appends hash-chained records to a local file.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	seq  int64
+	last string
+}
+
+// Open verifies the existing log at path and continues its chain.
+func Open(path string) (*Log, error) {
+	last, err := Verify(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: file, seq: last.Seq, last: last.Hash}, nil
+}
+
+func (l *Log) Append(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	r.Seq = l.seq
+	r.Prev = l.last
+	r.Hash = r.computeHash()
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.last = r.Hash
+	return nil
+}
+
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Verify checks every record and link of the log at path and returns the last
+// record. Keep its hash somewhere else as well: a log cut off after it can only be told
+// apart from a complete one by comparing the last hash.
+func Verify(path string) (Record, error) {
+	var last Record
+	file, err := os.Open(path)
+	if err != nil {
+		return last, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return last, fmt.Errorf("line %d: malformed record: %v", line, err)
+		}
+		switch {
+		case r.Seq != last.Seq+1:
+			return last, fmt.Errorf("line %d: sequence %d follows %d", line, r.Seq, last.Seq)
+		case r.Prev != last.Hash:
+			return last, fmt.Errorf("line %d: record %d does not chain to record %d", line, r.Seq, last.Seq)
+		case r.Hash != r.computeHash():
+			return last, fmt.Errorf("line %d: record %d was modified", line, r.Seq)
+		}
+		last = r
+	}
+	return last, scanner.Err()
+}
+
+// Config lists the mutating methods to audit. The function names the entity a request
+// (or client stream message) changes, e.g. the order ID; it may be nil.
+type Config map[string]func(req interface{}) string
+
+// Auditor records every mutating call, and every message of mutating client streams,
+// in a Log: a call with the status it ended with, a stream message as soon as the handler
+// receives it, and then the stream with the status it ended with.
+type Auditor struct {
+	log    *Log
+	config Config
+}
+
+func NewAuditor(log *Log, config Config) *Auditor {
+	return &Auditor{log: log, config: config}
+}
+
+func (a *Auditor) record(ctx context.Context, method, stream string, req interface{}, digest, outcome string) {
+	r := Record{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Method: method,
+		Caller: identity.Caller(ctx),
+		Stream: stream,
+		Digest: digest,
+		Status: outcome,
+	}
+	if target := a.config[method]; target != nil && req != nil {
+		r.Target = target(req)
+	}
+	if err := a.log.Append(r); err != nil {
+		log.Printf("[Audit Interceptor] failed to record %s: %v", method, err)
+	}
+}
+
+func requestDigest(req interface{}) string {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// records the audited calls with their outcome, rejected ones included
+func (a *Auditor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := a.config[info.FullMethod]; !ok {
+			return handler(ctx, req)
+		}
+		// digest the request as the client sent it, before the handler fills in anything
+		digest := requestDigest(req)
+		m, err := handler(ctx, req)
+		a.record(ctx, info.FullMethod, "", req, digest, status.Code(err).String())
+		return m, err
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// records the final status of the audited streams once they end, rejected ones included;
+// put it outside the authorization interceptors and MessageStreamInterceptor inside them,
+// so only the messages they let through are recorded
+func (a *Auditor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, ok := a.config[info.FullMethod]; !ok {
+			return handler(srv, ss)
+		}
+		id := newStreamID()
+		ctx := context.WithValue(ss.Context(), streamKey{}, id)
+		err := handler(srv, &streamIDStream{ServerStream: ss, ctx: ctx})
+		a.record(ss.Context(), info.FullMethod, id, nil, "", status.Code(err).String())
+		return err
+	}
+}
+
+// MessageStreamInterceptor Server :: Stream Interceptor
+// records every message the handler of an audited stream actually receives, as it
+// receives it, under the stream ID StreamServerInterceptor records the outcome with
+func (a *Auditor) MessageStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id, ok := ss.Context().Value(streamKey{}).(string)
+		if !ok {
+			return handler(srv, ss)
+		}
+		return handler(srv, &auditedStream{ServerStream: ss, auditor: a, method: info.FullMethod, id: id})
+	}
+}
+
+type streamKey struct{}
+
+func newStreamID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+type streamIDStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *streamIDStream) Context() context.Context {
+	return s.ctx
+}
+
+type auditedStream struct {
+	grpc.ServerStream
+	auditor *Auditor
+	method  string
+	id      string
+}
+
+func (s *auditedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		// digest the message as the client sent it, before the handler changes anything
+		s.auditor.record(s.Context(), s.method, s.id, m, requestDigest(m), Received)
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const method = "/proto.OrderManagement/processOrders"
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	msgs []string
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	if len(s.msgs) == 0 {
+		return io.EOF
+	}
+	m.(*wrapperspb.StringValue).Value, s.msgs = s.msgs[0], s.msgs[1:]
+	return nil
+}
+
+// rejectStream stands in for an authorization interceptor that turns down a message.
+type rejectStream struct {
+	grpc.ServerStream
+	reject string
+}
+
+func (s *rejectStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if m.(*wrapperspb.StringValue).Value == s.reject {
+		return status.Error(codes.ResourceExhausted, "rejected")
+	}
+	return nil
+}
+
+func newTestAuditor(t *testing.T) (*Auditor, string) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return NewAuditor(l, Config{method: func(req interface{}) string {
+		return "order/" + req.(*wrapperspb.StringValue).Value
+	}}), path
+}
+
+// serve runs handler behind the auditor's two stream interceptors, with authz
+// between them the way a server chains them.
+func serve(a *Auditor, ss grpc.ServerStream, authz func(grpc.ServerStream) grpc.ServerStream, handler grpc.StreamHandler) error {
+	info := &grpc.StreamServerInfo{FullMethod: method}
+	return a.StreamServerInterceptor()(nil, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+		if authz == nil {
+			return status.Error(codes.PermissionDenied, "denied")
+		}
+		return a.MessageStreamInterceptor()(srv, authz(ss), info, handler)
+	})
+}
+
+func readAll(srv interface{}, ss grpc.ServerStream) error {
+	for {
+		if err := ss.RecvMsg(&wrapperspb.StringValue{}); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func records(t *testing.T, path string) []Record {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rs []Record
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func TestStreamRecordsAcceptedMessagesWithOutcome(t *testing.T) {
+	a, path := newTestAuditor(t)
+	ss := &fakeStream{ctx: context.Background(), msgs: []string{"101", "102", "103"}}
+	authz := func(ss grpc.ServerStream) grpc.ServerStream { return &rejectStream{ServerStream: ss, reject: "103"} }
+	if err := serve(a, ss, authz, readAll); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("serve = %v, want ResourceExhausted", err)
+	}
+
+	rs := records(t, path)
+	if len(rs) != 3 {
+		t.Fatalf("got %d records, want the 2 accepted messages and the outcome: %+v", len(rs), rs)
+	}
+	for i, want := range []Record{
+		{Target: "order/101", Status: Received},
+		{Target: "order/102", Status: Received},
+		{Target: "", Status: codes.ResourceExhausted.String()},
+	} {
+		if rs[i].Target != want.Target || rs[i].Status != want.Status {
+			t.Errorf("record %d = %q %s, want %q %s", i, rs[i].Target, rs[i].Status, want.Target, want.Status)
+		}
+		if rs[i].Stream == "" || rs[i].Stream != rs[0].Stream {
+			t.Errorf("record %d is of stream %q, want all of stream %q", i, rs[i].Stream, rs[0].Stream)
+		}
+	}
+	if rs[0].Digest == "" || rs[0].Digest == rs[1].Digest || rs[2].Digest != "" {
+		t.Errorf("digests %q %q %q, want one per message and none for the outcome", rs[0].Digest, rs[1].Digest, rs[2].Digest)
+	}
+}
+
+func TestStreamRecordsMessagesAsTheyArrive(t *testing.T) {
+	a, path := newTestAuditor(t)
+	ss := &fakeStream{ctx: context.Background(), msgs: []string{"101", "102"}}
+	seen := 0
+	err := serve(a, ss, func(ss grpc.ServerStream) grpc.ServerStream { return ss }, func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			if err := ss.RecvMsg(&wrapperspb.StringValue{}); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			seen++
+			// the stream is still open, a crash now must not lose what it already changed
+			if n := len(records(t, path)); n != seen {
+				t.Errorf("%d records after %d messages of an open stream", n, seen)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(records(t, path)); n != 3 {
+		t.Errorf("%d records after the stream, want 2 messages and the outcome", n)
+	}
+}
+
+func TestStreamRecordsDeniedStream(t *testing.T) {
+	a, path := newTestAuditor(t)
+	ss := &fakeStream{ctx: context.Background(), msgs: []string{"101"}}
+	if err := serve(a, ss, nil, readAll); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("serve = %v, want PermissionDenied", err)
+	}
+
+	rs := records(t, path)
+	if len(rs) != 1 || rs[0].Target != "" || rs[0].Status != codes.PermissionDenied.String() {
+		t.Fatalf("got %+v, want one PermissionDenied record without a target", rs)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	a, path := newTestAuditor(t)
+	ss := &fakeStream{ctx: context.Background(), msgs: []string{"101", "102"}}
+	if err := serve(a, ss, func(ss grpc.ServerStream) grpc.ServerStream { return ss }, readAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(path); err != nil {
+		t.Fatalf("Verify of an untouched log: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), "order/102", "order/999", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(path); err == nil {
+		t.Fatal("Verify accepted a modified record")
+	}
+}
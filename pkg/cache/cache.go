@@ -0,0 +1,230 @@
+// Package cache answers repeated reads of a server from memory.
+package cache
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"sync"
+	"time"
+)
+
+// StaleRead names a cached read that a write has made stale: the read method and the
+// request it was called with.
+type StaleRead struct {
+	Method  string
+	Request proto.Message
+}
+
+// Config lists the read methods to cache with their TTL, and for every write method
+// the reads each of its requests (or client stream messages) makes stale. resp is the
+// response of a unary write and nil for stream messages.
+type Config struct {
+	TTLs          map[string]time.Duration
+	Invalidations map[string]func(req, resp interface{}) []StaleRead
+}
+
+// Stats are the counters of one cached method.
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+}
+
+type cacheEntry struct {
+	resp    proto.Message
+	expires time.Time
+}
+
+// ResponseCache answers repeated reads from memory. The cache key is the method and the
+// deterministic encoding of the request message, so equal requests share one entry.
+type ResponseCache struct {
+	config Config
+	// now is time.Now, tests move it forward to expire entries
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	stats     map[string]*Stats
+	writes    uint64
+	lastSweep time.Time
+}
+
+func NewResponseCache(config Config) *ResponseCache {
+	return &ResponseCache{
+		config:    config,
+		now:       time.Now,
+		entries:   make(map[string]cacheEntry),
+		stats:     make(map[string]*Stats),
+		lastSweep: time.Now(),
+	}
+}
+
+// Stats returns a copy of the counters of every cached method.
+func (c *ResponseCache) Stats() map[string]Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[string]Stats, len(c.stats))
+	for method, s := range c.stats {
+		stats[method] = *s
+	}
+	return stats
+}
+
+// Report logs the counters of every cached method each interval until stop is closed.
+func (c *ResponseCache) Report(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for method, s := range c.Stats() {
+				log.Printf("[Cache Interceptor] %s: %d hits, %d misses, %d invalidations", method, s.Hits, s.Misses, s.Invalidations)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func cacheKey(method string, req interface{}) (string, bool) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return "", false
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", false
+	}
+	return method + "\x00" + string(data), true
+}
+
+func (c *ResponseCache) methodStats(method string) *Stats {
+	s, ok := c.stats[method]
+	if !ok {
+		s = &Stats{}
+		c.stats[method] = s
+	}
+	return s
+}
+
+func (c *ResponseCache) get(method, key string) (proto.Message, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if ok && c.now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.methodStats(method).Misses++
+		return nil, c.writes, false
+	}
+	c.methodStats(method).Hits++
+	return proto.Clone(entry.resp), c.writes, true
+}
+
+// put stores resp unless a write happened since the handler started, which may
+// already have made resp stale.
+func (c *ResponseCache) put(key string, resp proto.Message, ttl time.Duration, writes uint64) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writes != writes {
+		return
+	}
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = cacheEntry{resp: proto.Clone(resp), expires: now.Add(ttl)}
+}
+
+func (c *ResponseCache) invalidate(method string, req, resp interface{}) []StaleRead {
+	invalidation, ok := c.config.Invalidations[method]
+	if !ok {
+		return nil
+	}
+	stale := invalidation(req, resp)
+	c.drop(stale)
+	return stale
+}
+
+func (c *ResponseCache) drop(stale []StaleRead) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	for _, read := range stale {
+		key, ok := cacheKey(read.Method, read.Request)
+		if !ok {
+			continue
+		}
+		if _, cached := c.entries[key]; cached {
+			delete(c.entries, key)
+			c.methodStats(read.Method).Invalidations++
+		}
+	}
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// answers cached reads from memory and drops the entries a write makes stale
+func (c *ResponseCache) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ttl, cached := c.config.TTLs[info.FullMethod]
+		key, ok := cacheKey(info.FullMethod, req)
+		if !cached || !ok {
+			m, err := handler(ctx, req)
+			if err == nil {
+				c.invalidate(info.FullMethod, req, m)
+			}
+			return m, err
+		}
+
+		resp, writes, hit := c.get(info.FullMethod, key)
+		if hit {
+			log.Printf("[Cache Interceptor] %s served from cache", info.FullMethod)
+			return resp, nil
+		}
+		m, err := handler(ctx, req)
+		if msg, ok := m.(proto.Message); ok && err == nil {
+			c.put(key, msg, ttl, writes)
+		}
+		return m, err
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// drops the entries made stale by every message a client stream writes, once when the
+// message arrives and again when the handler is done applying the stream
+func (c *ResponseCache) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, ok := c.config.Invalidations[info.FullMethod]; !ok || !info.IsClientStream {
+			return handler(srv, ss)
+		}
+		stream := &invalidatingStream{ServerStream: ss, cache: c, method: info.FullMethod}
+		err := handler(srv, stream)
+		// a read between the arrival of a message and the handler storing it may have cached the old value
+		c.drop(stream.stale)
+		return err
+	}
+}
+
+type invalidatingStream struct {
+	grpc.ServerStream
+	cache  *ResponseCache
+	method string
+	stale  []StaleRead
+}
+
+func (s *invalidatingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.stale = append(s.stale, s.cache.invalidate(s.method, m, nil)...)
+	}
+	return err
+}
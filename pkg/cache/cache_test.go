@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	getMethod    = "/proto.OrderManagement/getOrder"
+	updateMethod = "/proto.OrderManagement/updateOrders"
+	addMethod    = "/proto.OrderManagement/addOrder"
+)
+
+// newTestCache caches getOrder for a minute on a clock that only moves when the test says so.
+// Both writes make the getOrder of the order they name stale.
+func newTestCache(t *testing.T) (*ResponseCache, *time.Time) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	stale := func(req, resp interface{}) []StaleRead {
+		return []StaleRead{{Method: getMethod, Request: wrapperspb.String(req.(*wrapperspb.StringValue).Value)}}
+	}
+	c := NewResponseCache(Config{
+		TTLs:          map[string]time.Duration{getMethod: time.Minute},
+		Invalidations: map[string]func(req, resp interface{}) []StaleRead{updateMethod: stale, addMethod: stale},
+	})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+// orders counts the calls that reach the handler and answers with a new value every time.
+type orders struct {
+	calls int
+}
+
+func (o *orders) get(t *testing.T, c *ResponseCache, id string) string {
+	t.Helper()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		o.calls++
+		return wrapperspb.String(req.(*wrapperspb.StringValue).Value + "@" + strconv.Itoa(o.calls)), nil
+	}
+	resp, err := c.UnaryServerInterceptor()(context.Background(), wrapperspb.String(id), &grpc.UnaryServerInfo{FullMethod: getMethod}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.(*wrapperspb.StringValue).Value
+}
+
+func TestCacheKeyIsTheRequestMessage(t *testing.T) {
+	c, _ := newTestCache(t)
+	o := &orders{}
+
+	first := o.get(t, c, "101")
+	if again := o.get(t, c, "101"); again != first || o.calls != 1 {
+		t.Errorf("an equal request got %q after %d calls, want the cached %q", again, o.calls, first)
+	}
+	if other := o.get(t, c, "102"); other == first || o.calls != 2 {
+		t.Errorf("another request got %q after %d calls, want its own answer", other, o.calls)
+	}
+
+	// the same message sent to a method that is not cached always reaches the handler
+	for i := 0; i < 2; i++ {
+		_, err := c.UnaryServerInterceptor()(context.Background(), wrapperspb.String("101"), &grpc.UnaryServerInfo{FullMethod: "/proto.OrderManagement/searchOrders"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				o.calls++
+				return wrapperspb.String("search"), nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if o.calls != 4 {
+		t.Errorf("%d calls reached the handlers, want 4", o.calls)
+	}
+}
+
+func TestCacheEntriesExpire(t *testing.T) {
+	c, now := newTestCache(t)
+	o := &orders{}
+
+	first := o.get(t, c, "101")
+	*now = now.Add(time.Minute - time.Second)
+	if got := o.get(t, c, "101"); got != first {
+		t.Errorf("got %q before the TTL, want the cached %q", got, first)
+	}
+	*now = now.Add(time.Second * 2)
+	if got := o.get(t, c, "101"); got == first || o.calls != 2 {
+		t.Errorf("got %q after the TTL in %d calls, want a new answer", got, o.calls)
+	}
+}
+
+// recvStream is a client stream that delivers msgs, then io.EOF.
+type recvStream struct {
+	grpc.ServerStream
+	msgs []string
+}
+
+func (s *recvStream) Context() context.Context { return context.Background() }
+
+func (s *recvStream) RecvMsg(m interface{}) error {
+	if len(s.msgs) == 0 {
+		return io.EOF
+	}
+	proto.Merge(m.(proto.Message), wrapperspb.String(s.msgs[0]))
+	s.msgs = s.msgs[1:]
+	return nil
+}
+
+func TestCacheWritesInvalidate(t *testing.T) {
+	c, _ := newTestCache(t)
+	o := &orders{}
+	write := func(ctx context.Context, req interface{}) (interface{}, error) { return &wrapperspb.StringValue{}, nil }
+
+	// a unary write drops the order it names, and only that one
+	first, other := o.get(t, c, "101"), o.get(t, c, "102")
+	if _, err := c.UnaryServerInterceptor()(context.Background(), wrapperspb.String("101"), &grpc.UnaryServerInfo{FullMethod: addMethod}, write); err != nil {
+		t.Fatal(err)
+	}
+	if got := o.get(t, c, "101"); got == first {
+		t.Errorf("order 101 still answered from the cache after a write")
+	}
+	if got := o.get(t, c, "102"); got != other {
+		t.Errorf("order 102 got %q, want the cached %q", got, other)
+	}
+
+	// so does every message of a client stream
+	first, other = o.get(t, c, "101"), o.get(t, c, "102")
+	stream := &recvStream{msgs: []string{"101", "102"}}
+	err := c.StreamServerInterceptor()(nil, stream, &grpc.StreamServerInfo{FullMethod: updateMethod, IsClientStream: true}, func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			if err := ss.RecvMsg(&wrapperspb.StringValue{}); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.get(t, c, "101") == first || o.get(t, c, "102") == other {
+		t.Errorf("orders written by the stream are still answered from the cache")
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c, _ := newTestCache(t)
+	o := &orders{}
+	for _, id := range []string{"101", "101", "101", "102"} {
+		o.get(t, c, id)
+	}
+	c.drop([]StaleRead{{Method: getMethod, Request: wrapperspb.String("101")}, {Method: getMethod, Request: wrapperspb.String("103")}})
+
+	want := Stats{Hits: 2, Misses: 2, Invalidations: 1}
+	if got := c.Stats()[getMethod]; got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
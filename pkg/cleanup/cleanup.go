@@ -0,0 +1,96 @@
+// Package cleanup runs the cleanup and rollback hooks a handler registered once its call ends.
+package cleanup
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"log"
+	"sync"
+)
+
+type cleanupKey struct{}
+
+// hooks are the cleanup and rollback functions a handler registered for its call.
+type hooks struct {
+	mu       sync.Mutex
+	cleanup  []func()
+	rollback []func()
+}
+
+func hooksFromContext(ctx context.Context) (*hooks, bool) {
+	h, ok := ctx.Value(cleanupKey{}).(*hooks)
+	return h, ok
+}
+
+// Defer registers fn to run when the call ends, however it ends. It reports false,
+// and fn never runs, outside of the interceptors of this package.
+func Defer(ctx context.Context, fn func()) bool {
+	h, ok := hooksFromContext(ctx)
+	if ok {
+		h.mu.Lock()
+		h.cleanup = append(h.cleanup, fn)
+		h.mu.Unlock()
+	}
+	return ok
+}
+
+// OnRollback registers fn to undo the work of the call when it fails or is cancelled,
+// e.g. to restore the orders of an UpdateOrders batch the client gave up on. It reports
+// false, and fn never runs, outside of the interceptors of this package.
+func OnRollback(ctx context.Context, fn func()) bool {
+	h, ok := hooksFromContext(ctx)
+	if ok {
+		h.mu.Lock()
+		h.rollback = append(h.rollback, fn)
+		h.mu.Unlock()
+	}
+	return ok
+}
+
+// run calls the rollbacks of a failed call, then the cleanups, each in the reverse order
+// of registration like deferred calls.
+func (h *hooks) run(fullMethod string, failed bool) {
+	h.mu.Lock()
+	cleanup, rollback := h.cleanup, h.rollback
+	h.cleanup, h.rollback = nil, nil
+	h.mu.Unlock()
+
+	if failed && len(rollback) > 0 {
+		log.Printf("[Cleanup Interceptor] %s failed, running %d rollback hooks", fullMethod, len(rollback))
+		for i := len(rollback) - 1; i >= 0; i-- {
+			rollback[i]()
+		}
+	}
+	for i := len(cleanup) - 1; i >= 0; i-- {
+		cleanup[i]()
+	}
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// runs the hooks the handler registered once it returns
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (m interface{}, err error) {
+	h := &hooks{}
+	ctx = context.WithValue(ctx, cleanupKey{}, h)
+	defer func() { h.run(info.FullMethod, err != nil || ctx.Err() != nil) }()
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// runs the hooks the handler registered once it returns. A stream whose client went away
+// counts as failed even if the handler did not notice.
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	h := &hooks{}
+	ctx := context.WithValue(ss.Context(), cleanupKey{}, h)
+	defer func() { h.run(info.FullMethod, err != nil || ctx.Err() != nil) }()
+	return handler(srv, &hookedStream{ServerStream: ss, ctx: ctx})
+}
+
+// hookedStream replaces the context of the embedded grpc.ServerStream.
+type hookedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *hookedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,131 @@
+// Package concurrency sheds the load a server cannot keep up with.
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Config tunes the AIMD concurrency limit: the limit grows by one every
+// time a full window of calls finishes within LatencyTarget, and is multiplied by
+// Backoff as soon as one call is slower than that or runs out of its deadline.
+type Config struct {
+	Initial       int
+	Min           int
+	Max           int
+	LatencyTarget time.Duration
+	Backoff       float64
+}
+
+// AdaptiveLimiter bounds the number of RPCs running at the same time. Calls over the
+// current limit are shed with Unavailable right away instead of queueing behind slow ones.
+type AdaptiveLimiter struct {
+	config Config
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	shed     uint64
+}
+
+func NewAdaptiveLimiter(config Config) *AdaptiveLimiter {
+	if config.Min < 1 {
+		config.Min = 1
+	}
+	if config.Max < config.Min {
+		config.Max = config.Min
+	}
+	if config.Initial < config.Min || config.Initial > config.Max {
+		config.Initial = config.Min
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = 0.9
+	}
+	return &AdaptiveLimiter{config: config, limit: float64(config.Initial)}
+}
+
+// Limit is the number of concurrent calls currently allowed.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight is the number of calls running now.
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Shed is the number of calls rejected so far.
+func (l *AdaptiveLimiter) Shed() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.shed
+}
+
+func (l *AdaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= int(l.limit) {
+		l.shed++
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+func (l *AdaptiveLimiter) release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+
+	if latency > l.config.LatencyTarget || status.Code(err) == codes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		l.limit = math.Max(float64(l.config.Min), l.limit*l.config.Backoff)
+		return
+	}
+	// +1/limit per call adds up to +1 per window of limit calls
+	l.limit = math.Min(float64(l.config.Max), l.limit+1/l.limit)
+}
+
+func (l *AdaptiveLimiter) rejection(fullMethod string) error {
+	return status.Errorf(codes.Unavailable, "server overloaded, %s shed at concurrency limit %d", fullMethod, l.Limit())
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// sheds the calls over the concurrency limit and adapts the limit to the observed latency
+func (l *AdaptiveLimiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (m interface{}, err error) {
+		if !l.acquire() {
+			err := l.rejection(info.FullMethod)
+			log.Printf("[Concurrency Interceptor] %v", err)
+			return nil, err
+		}
+		start := time.Now()
+		defer func() { l.release(time.Since(start), err) }()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// sheds the streams over the concurrency limit. A stream lives as long as its client wants,
+// so only its errors, not its duration, lower the limit.
+func (l *AdaptiveLimiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		if !l.acquire() {
+			err := l.rejection(info.FullMethod)
+			log.Printf("[Concurrency Interceptor] %v", err)
+			return err
+		}
+		defer func() { l.release(0, err) }()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,90 @@
+package concurrency
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAdaptiveLimiterBoundsLatency overloads a handler that can work on a few calls at a time,
+// so every call over that waits for a free worker, the way a slow server queues its requests.
+// Without the limiter the latency grows with the number of callers; with it the excess is
+// shed and the accepted calls stay close to the latency target.
+func TestAdaptiveLimiterBoundsLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+	const (
+		callers = 200
+		workers = 4
+		work    = time.Millisecond * 10
+		target  = time.Millisecond * 50
+		// unlimited, every call waits for the callers/workers calls queued before it
+		unbounded = callers / workers * work
+	)
+	limiter := NewAdaptiveLimiter(Config{Initial: 50, Min: 1, Max: callers, LatencyTarget: target, Backoff: 0.9})
+	interceptor := limiter.UnaryServerInterceptor()
+	slots := make(chan struct{}, workers)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		time.Sleep(work)
+		return req, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.OrderManagement/getOrder"}
+
+	var (
+		mu       sync.Mutex
+		accepted []time.Duration
+		shed     int
+		wg       sync.WaitGroup
+	)
+	stop := time.Now().Add(time.Second * 2)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				start := time.Now()
+				_, err := interceptor(context.Background(), "101", info, handler)
+				latency := time.Since(start)
+
+				mu.Lock()
+				switch status.Code(err) {
+				case codes.OK:
+					accepted = append(accepted, latency)
+				case codes.Unavailable:
+					shed++
+				default:
+					t.Errorf("call failed: %v", err)
+				}
+				mu.Unlock()
+				if err != nil {
+					time.Sleep(work)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(accepted) == 0 || shed == 0 {
+		t.Fatalf("%d calls accepted, %d shed: want both", len(accepted), shed)
+	}
+	sort.Slice(accepted, func(i, j int) bool { return accepted[i] < accepted[j] })
+	p99 := accepted[len(accepted)*99/100]
+	t.Logf("%d calls accepted with p99 %v, %d shed, limit settled at %d", len(accepted), p99, shed, limiter.Limit())
+	if p99 > unbounded/2 {
+		t.Errorf("p99 latency of the accepted calls is %v, want it bounded well below the %v of an unlimited server", p99, unbounded)
+	}
+	if limiter.Limit() >= 50 {
+		t.Errorf("limit is %d, want it lowered below its initial 50 by the slow calls", limiter.Limit())
+	}
+	if limiter.InFlight() != 0 {
+		t.Errorf("%d calls still in flight after the load", limiter.InFlight())
+	}
+}
@@ -0,0 +1,137 @@
+package deadline
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+)
+
+// BudgetConfig gives every method the least time it needs to be worth starting, and
+// keeps Reserve of every call's deadline, but never more than half, back for the handler
+// to answer after its downstream calls are done.
+type BudgetConfig struct {
+	MinBudgets map[string]time.Duration
+	Reserve    time.Duration
+}
+
+type budgetKey struct{}
+
+// Budget turns away calls that cannot finish before their deadline, and passes
+// what is left of the deadline on to the handler and its downstream calls.
+type Budget struct {
+	config BudgetConfig
+}
+
+func NewBudget(config BudgetConfig) *Budget {
+	return &Budget{config: config}
+}
+
+// Remaining is the time a handler has left for its work and downstream calls. It is false
+// for calls without a deadline, or outside of the Budget interceptors.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Value(budgetKey{}).(time.Time)
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// admit checks the remaining deadline of the call against the minimum of the method, and
+// records the deadline of downstream calls in the returned context.
+func (b *Budget) admit(ctx context.Context, fullMethod string) (context.Context, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx, nil
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return ctx, status.Errorf(codes.DeadlineExceeded, "%s arrived after its deadline", fullMethod)
+	}
+	if least := b.config.MinBudgets[fullMethod]; remaining < least {
+		log.Printf("[Budget Interceptor] %s rejected with %v left of at least %v", fullMethod, remaining, least)
+		return ctx, status.Errorf(codes.DeadlineExceeded, "%s needs at least %v, the deadline leaves %v", fullMethod, least, remaining)
+	}
+	// a call with less than twice the reserve left splits it between the downstream calls
+	// and the answer, rather than sending them out with a deadline already past
+	reserve := b.config.Reserve
+	if reserve > remaining/2 {
+		reserve = remaining / 2
+	}
+	return context.WithValue(ctx, budgetKey{}, deadline.Add(-reserve)), nil
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// rejects calls with too little time left before running them
+func (b *Budget) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := b.admit(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// rejects streams with too little time left before opening them
+func (b *Budget) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := b.admit(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &deadlineStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UnaryClientInterceptor Client :: Unary Interceptor
+// for the connections a handler calls other servers on: a call made with the handler's
+// context gets the handler's budget as its deadline, so that the reserve is left when it times out
+func (b *Budget) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		deadline, ok := ctx.Value(budgetKey{}).(time.Time)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor Client :: Stream Interceptor
+// is UnaryClientInterceptor for streams
+func (b *Budget) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		deadline, ok := ctx.Value(budgetKey{}).(time.Time)
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &budgetStream{ClientStream: cs, serverStreams: desc.ServerStreams, cancel: cancel}, nil
+	}
+}
+
+// budgetStream releases the deadline timer of the stream once it has ended. An abandoned
+// stream keeps it until the deadline, which is never later than the handler's own.
+type budgetStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	cancel        context.CancelFunc
+}
+
+func (s *budgetStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.cancel()
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package deadline
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+const method = "/proto.OrderManagement/getOrder"
+
+// downstreamDeadline runs a call through the server interceptor and returns the deadline
+// a downstream call made by its handler gets from the client interceptor.
+func downstreamDeadline(t *testing.T, b *Budget, timeout time.Duration) (time.Duration, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var left time.Duration
+	_, err := b.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, b.UnaryClientInterceptor()(ctx, method, nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("downstream call has no deadline")
+			}
+			left = time.Until(deadline)
+			return ctx.Err()
+		})
+	})
+	return left, err
+}
+
+func TestBudgetKeepsReserve(t *testing.T) {
+	b := NewBudget(BudgetConfig{Reserve: 100 * time.Millisecond})
+	left, err := downstreamDeadline(t, b, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left > 900*time.Millisecond || left < 800*time.Millisecond {
+		t.Errorf("downstream has %v left, want about 900ms", left)
+	}
+}
+
+func TestBudgetNeverPassesAPastDeadline(t *testing.T) {
+	b := NewBudget(BudgetConfig{Reserve: time.Second})
+	left, err := downstreamDeadline(t, b, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("downstream call failed: %v", err)
+	}
+	if left <= 0 || left > 100*time.Millisecond {
+		t.Errorf("downstream has %v left, want half of the 200ms", left)
+	}
+}
+
+func TestBudgetRejectsBelowMinimum(t *testing.T) {
+	b := NewBudget(BudgetConfig{MinBudgets: map[string]time.Duration{method: time.Second}})
+	if _, err := downstreamDeadline(t, b, 100*time.Millisecond); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
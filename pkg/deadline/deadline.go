@@ -0,0 +1,128 @@
+// Package deadline bounds the time the calls of a server may take.
+package deadline
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Limit is applied to the calls of one method: Default to the calls sent without a
+// deadline, Max to the ones sent with a longer one. Zero values leave the deadline as it is.
+type Limit struct {
+	Default jsonconfig.Duration `json:"default"`
+	Max     jsonconfig.Duration `json:"max"`
+}
+
+// Config gives every method its own limit, and Default to the others.
+type Config struct {
+	Default Limit            `json:"default"`
+	Methods map[string]Limit `json:"methods"`
+}
+
+func LoadConfig(path string) (Config, error) {
+	config := Config{}
+	err := jsonconfig.Load(path, &config)
+	return config, err
+}
+
+func (c Config) limit(fullMethod string) Limit {
+	if l, ok := c.Methods[fullMethod]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// ServerDeadlines bounds the time every call may run on the server, whatever deadline its
+// client sent.
+type ServerDeadlines struct {
+	config Config
+}
+
+func NewServerDeadlines(config Config) *ServerDeadlines {
+	return &ServerDeadlines{config: config}
+}
+
+// apply returns the context of the call with the server's deadline, and whether it set one.
+func (d *ServerDeadlines) apply(ctx context.Context, fullMethod string) (context.Context, context.CancelFunc, bool) {
+	limit := d.config.limit(fullMethod)
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok && limit.Default > 0:
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(limit.Default))
+		return ctx, cancel, true
+	case ok && limit.Max > 0 && time.Until(deadline) > time.Duration(limit.Max):
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(limit.Max))
+		return ctx, cancel, true
+	}
+	return ctx, func() {}, false
+}
+
+// spent is the error of a call that arrived with its deadline already over: its client
+// has given up, so the handler is not run at all.
+func spent(ctx context.Context, fullMethod string) error {
+	if ctx.Err() != nil {
+		return status.Errorf(codes.DeadlineExceeded, "%s arrived after its deadline", fullMethod)
+	}
+	return nil
+}
+
+// exceeded is the error of a call that outlived the server's deadline. The client's own
+// deadline is later or missing, so without it the client would get the late answer.
+func exceeded(ctx context.Context, fullMethod string, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return status.Errorf(codes.DeadlineExceeded, "%s ran out of the server's deadline", fullMethod)
+	}
+	return err
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// gives calls without a deadline the method's default, caps longer ones at its maximum
+// and rejects the ones that arrive too late
+func (d *ServerDeadlines) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := spent(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		ctx, cancel, set := d.apply(ctx, info.FullMethod)
+		defer cancel()
+		m, err := handler(ctx, req)
+		if set {
+			if err := exceeded(ctx, info.FullMethod, err); err != nil {
+				return nil, err
+			}
+		}
+		return m, err
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// gives streams without a deadline the method's default, caps longer ones at its maximum
+// and rejects the ones that arrive too late
+func (d *ServerDeadlines) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := spent(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		ctx, cancel, set := d.apply(ss.Context(), info.FullMethod)
+		defer cancel()
+		err := handler(srv, &deadlineStream{ServerStream: ss, ctx: ctx})
+		if set {
+			return exceeded(ctx, info.FullMethod, err)
+		}
+		return err
+	}
+}
+
+// deadlineStream replaces the context of the embedded grpc.ServerStream.
+type deadlineStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *deadlineStream) Context() context.Context {
+	return s.ctx
+}
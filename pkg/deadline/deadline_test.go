@@ -0,0 +1,115 @@
+package deadline
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func newTestDeadlines() *ServerDeadlines {
+	return NewServerDeadlines(Config{
+		Methods: map[string]Limit{
+			method: {Default: jsonconfig.Duration(2 * time.Second), Max: jsonconfig.Duration(10 * time.Second)},
+		},
+	})
+}
+
+// handlerDeadline runs a unary call sent with the given timeout, 0 for none, and returns
+// what is left of the deadline the handler sees.
+func handlerDeadline(t *testing.T, d *ServerDeadlines, fullMethod string, timeout time.Duration) (time.Duration, bool) {
+	t.Helper()
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var (
+		left time.Duration
+		set  bool
+	)
+	_, err := d.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: fullMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		var deadline time.Time
+		deadline, set = ctx.Deadline()
+		left = time.Until(deadline)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return left, set
+}
+
+func TestServerDeadlinesPropagate(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"no deadline gets the default", method, 0, 2 * time.Second},
+		{"a shorter deadline is kept", method, time.Second, time.Second},
+		{"a deadline between default and maximum is kept", method, 5 * time.Second, 5 * time.Second},
+		{"a longer deadline is capped", method, time.Minute, 10 * time.Second},
+		{"another method keeps its deadline", "/proto.OrderManagement/searchOrders", time.Minute, time.Minute},
+	}
+	d := newTestDeadlines()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, set := handlerDeadline(t, d, tt.method, tt.timeout)
+			if !set || left > tt.want || left < tt.want-100*time.Millisecond {
+				t.Errorf("handler has %v left (deadline %v), want about %v", left, set, tt.want)
+			}
+		})
+	}
+
+	if _, set := handlerDeadline(t, d, "/proto.OrderManagement/searchOrders", 0); set {
+		t.Error("a method without a default got a deadline")
+	}
+}
+
+func TestServerDeadlinesRejectSpentDeadline(t *testing.T) {
+	d := newTestDeadlines()
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+	defer cancel()
+	called := false
+
+	_, err := d.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	if status.Code(err) != codes.DeadlineExceeded || called {
+		t.Errorf("unary call: %v with the handler called %v, want DeadlineExceeded without it", err, called)
+	}
+
+	err = d.StreamServerInterceptor()(nil, &contextOnlyStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: method}, func(srv interface{}, ss grpc.ServerStream) error {
+		called = true
+		return nil
+	})
+	if status.Code(err) != codes.DeadlineExceeded || called {
+		t.Errorf("stream: %v with the handler called %v, want DeadlineExceeded without it", err, called)
+	}
+}
+
+func TestServerDeadlinesFailLateAnswers(t *testing.T) {
+	d := NewServerDeadlines(Config{Default: Limit{Default: jsonconfig.Duration(50 * time.Millisecond)}})
+	// a handler that ignores its context still answers after the server's deadline
+	_, err := d.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "late", nil
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("late answer: %v, want DeadlineExceeded", err)
+	}
+}
+
+type contextOnlyStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextOnlyStream) Context() context.Context { return s.ctx }
@@ -0,0 +1,133 @@
+// Package endpoint lets the training servers listen, and their clients dial, over TCP, a Unix
+// domain socket or in process, picked by the form of the address:
+//
+//	host:port or tcp://host:port   TCP
+//	unix:///path/to/socket         a Unix domain socket, e.g. for a sidecar on the same host
+//	bufconn:name                   an in-process listener, for a client in the same process
+//
+// In-process addresses only make sense to code that runs server and client together, like
+// tests; the -listen and -address flags of the standalone programs refuse them. Callers
+// over a Unix socket have no address of their own: unless they authenticate, they all
+// share the rate limits of one caller, see identity.Caller.
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	unixPrefix    = "unix://"
+	bufconnPrefix = "bufconn:"
+	tcpPrefix     = "tcp://"
+	// bufSize is the buffer of an in-process connection in each direction
+	bufSize = 1 << 20
+)
+
+var (
+	inProcessMu sync.Mutex
+	inProcess   = make(map[string]*bufconn.Listener)
+)
+
+// ListenFlag registers -listen on the command line.
+func ListenFlag(address string) *string {
+	v := processAddress(address)
+	flag.Var(&v, "listen", "address to listen on: host:port or unix:///path/to/socket")
+	return (*string)(&v)
+}
+
+// AddressFlag registers -address on the command line.
+func AddressFlag(address string) *string {
+	v := processAddress(address)
+	flag.Var(&v, "address", "server to dial: host:port or unix:///path/to/socket")
+	return (*string)(&v)
+}
+
+// processAddress is an address given to a program on its command line, which can only
+// reach other processes.
+type processAddress string
+
+func (a *processAddress) String() string {
+	return string(*a)
+}
+
+func (a *processAddress) Set(value string) error {
+	if strings.HasPrefix(value, bufconnPrefix) {
+		return fmt.Errorf("%s addresses only reach the same process, use host:port or %s/path/to/socket", bufconnPrefix, unixPrefix)
+	}
+	*a = processAddress(value)
+	return nil
+}
+
+// Listen listens on address. A stale socket file left behind by a server that did not stop
+// cleanly is removed first; the socket file of the listener is removed when it closes.
+func Listen(address string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, unixPrefix):
+		path := strings.TrimPrefix(address, unixPrefix)
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	case strings.HasPrefix(address, bufconnPrefix):
+		name := strings.TrimPrefix(address, bufconnPrefix)
+		inProcessMu.Lock()
+		defer inProcessMu.Unlock()
+		if _, ok := inProcess[name]; ok {
+			return nil, fmt.Errorf("in-process listener %q already exists", name)
+		}
+		lis := bufconn.Listen(bufSize)
+		inProcess[name] = lis
+		return &inProcessListener{Listener: lis, name: name}, nil
+	default:
+		return net.Listen("tcp", strings.TrimPrefix(address, tcpPrefix))
+	}
+}
+
+// inProcessListener leaves the registry when it closes, so its name can be listened on again.
+type inProcessListener struct {
+	*bufconn.Listener
+	name string
+}
+
+func (l *inProcessListener) Close() error {
+	inProcessMu.Lock()
+	if inProcess[l.name] == l.Listener {
+		delete(inProcess, l.name)
+	}
+	inProcessMu.Unlock()
+	return l.Listener.Close()
+}
+
+// Dial connects to the server at target with grpc.Dial. gRPC resolves unix:// targets by
+// itself; bufconn: targets are connected to the listener of that name in this process.
+func Dial(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	switch {
+	case strings.HasPrefix(target, bufconnPrefix):
+		name := strings.TrimPrefix(target, bufconnPrefix)
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			inProcessMu.Lock()
+			lis, ok := inProcess[name]
+			inProcessMu.Unlock()
+			if !ok {
+				return nil, errors.New("no in-process listener " + name)
+			}
+			return lis.DialContext(ctx)
+		}))
+		return grpc.Dial("passthrough:///"+target, opts...)
+	case strings.HasPrefix(target, tcpPrefix):
+		return grpc.Dial(strings.TrimPrefix(target, tcpPrefix), opts...)
+	default:
+		return grpc.Dial(target, opts...)
+	}
+}
@@ -0,0 +1,47 @@
+package endpoint
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessAddressRefusesInProcess(t *testing.T) {
+	var a processAddress
+	for _, value := range []string{"127.0.0.1:20051", "tcp://127.0.0.1:20051", "unix:///tmp/orders.sock"} {
+		if err := a.Set(value); err != nil || a.String() != value {
+			t.Errorf("Set(%q) = %v, holds %q", value, err, a.String())
+		}
+	}
+	if err := a.Set("bufconn:orders"); err == nil {
+		t.Error("Set accepted an in-process address")
+	}
+}
+
+func TestListenAndDial(t *testing.T) {
+	for _, address := range []string{"bufconn:endpoint-test", "unix://" + filepath.Join(t.TempDir(), "orders.sock")} {
+		t.Run(address, func(t *testing.T) {
+			lis, err := Listen(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := grpc.NewServer()
+			healthpb.RegisterHealthServer(s, health.NewServer())
+			go s.Serve(lis)
+			defer s.Stop()
+
+			conn, err := Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package fault injects latency and errors into the calls of a server.
+package fault
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The metadata keys a caller sets to inject a fault into its own call, when the
+// Config allows it. They override the configured fault of the method field by field,
+// e.g. "fault-delay: 3s" or "fault-code: UNAVAILABLE" with "fault-percentage: 50".
+const (
+	faultDelayKey      = "fault-delay"
+	faultCodeKey       = "fault-code"
+	faultPercentageKey = "fault-percentage"
+	faultAbortAfterKey = "fault-abort-after"
+)
+
+// previousAttemptsKey is set by gRPC clients on the retries of a call, to the number of
+// attempts before this one.
+const previousAttemptsKey = "grpc-previous-rpc-attempts"
+
+// Fault is injected into Percentage percent of the calls of a method, or with
+// FailAttempts set into the first FailAttempts attempts of every call, retries included,
+// so that a client retrying more often always gets through. The call is held back by Delay
+// first, then fails with Code. On a stream with AbortAfter set, Code ends the stream once
+// that many messages went through in one direction instead, Aborted if Code is OK.
+type Fault struct {
+	Percentage   float64             `json:"percentage"`
+	FailAttempts int                 `json:"fail_attempts"`
+	Delay        jsonconfig.Duration `json:"delay"`
+	Code         codes.Code          `json:"code"`
+	Message      string              `json:"message"`
+	AbortAfter   int                 `json:"abort_after"`
+}
+
+// Config gives every method its fault. With Metadata set, callers can ask for
+// faults themselves through the fault-* metadata keys; leave it off outside of tests,
+// or any client can slow down or fail the server at will.
+type Config struct {
+	Methods  map[string]Fault `json:"methods"`
+	Metadata bool             `json:"metadata"`
+}
+
+func LoadConfig(path string) (Config, error) {
+	config := Config{}
+	err := jsonconfig.Load(path, &config)
+	return config, err
+}
+
+// Injector delays and fails calls on purpose, so that clients can be tried against
+// slow and broken servers without editing the handlers.
+type Injector struct {
+	config Config
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewInjector(config Config) *Injector {
+	return &Injector{config: config, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// fault returns the fault of this call, or false when the call is left alone.
+func (f *Injector) fault(ctx context.Context, fullMethod string) (Fault, bool) {
+	fault, ok := f.config.Methods[fullMethod]
+	if f.config.Metadata {
+		if md, found := metadata.FromIncomingContext(ctx); found {
+			ok = fromMetadata(md, &fault) || ok
+		}
+	}
+	if !ok {
+		return fault, false
+	}
+	if fault.FailAttempts > 0 {
+		return fault, previousAttempts(ctx) < fault.FailAttempts
+	}
+
+	f.mu.Lock()
+	roll := f.rand.Float64() * 100
+	f.mu.Unlock()
+	return fault, roll < fault.Percentage
+}
+
+// fromMetadata overrides the fields of fault the caller set, and reports whether it set any.
+// A caller asking for a fault without a percentage gets it on every call.
+func fromMetadata(md metadata.MD, fault *Fault) bool {
+	set := false
+	if v := md.Get(faultDelayKey); len(v) > 0 {
+		if d, err := time.ParseDuration(v[0]); err == nil {
+			fault.Delay, set = jsonconfig.Duration(d), true
+		}
+	}
+	if v := md.Get(faultCodeKey); len(v) > 0 {
+		if c, err := parseCode(v[0]); err == nil {
+			fault.Code, set = c, true
+		}
+	}
+	if v := md.Get(faultAbortAfterKey); len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			fault.AbortAfter, set = n, true
+		}
+	}
+	if v := md.Get(faultPercentageKey); len(v) > 0 {
+		if p, err := strconv.ParseFloat(v[0], 64); err == nil {
+			fault.Percentage, set = p, true
+		}
+	} else if set {
+		fault.Percentage = 100
+	}
+	return set
+}
+
+// previousAttempts is the number of attempts of the call before this one, 0 on the first.
+func previousAttempts(ctx context.Context) int {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(previousAttemptsKey); len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// parseCode accepts a code by number or by name, e.g. 14 or UNAVAILABLE.
+func parseCode(s string) (codes.Code, error) {
+	var c codes.Code
+	if n, err := strconv.Atoi(s); err == nil {
+		return codes.Code(n), nil
+	}
+	err := c.UnmarshalJSON([]byte(strconv.Quote(s)))
+	return c, err
+}
+
+func (fault Fault) err(fullMethod string) error {
+	msg := fault.Message
+	if msg == "" {
+		msg = "fault injected into " + fullMethod
+	}
+	code := fault.Code
+	if code == codes.OK {
+		code = codes.Aborted
+	}
+	return status.Error(code, msg)
+}
+
+// delay waits out the fault's delay. A caller that gives up earlier ends the wait, and
+// the handler then finds its context done as it would after a slow call.
+func (fault Fault) delay(ctx context.Context) {
+	if fault.Delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(time.Duration(fault.Delay))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// delays the call and fails it with the configured code
+func (f *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		fault, inject := f.fault(ctx, info.FullMethod)
+		if !inject {
+			return handler(ctx, req)
+		}
+		log.Printf("[Fault Interceptor] %s: delay %v, code %v", info.FullMethod, time.Duration(fault.Delay), fault.Code)
+		fault.delay(ctx)
+		if fault.Code != codes.OK {
+			return nil, fault.err(info.FullMethod)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// delays the stream, then fails it right away or aborts it after the configured number of messages
+func (f *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		fault, inject := f.fault(ss.Context(), info.FullMethod)
+		if !inject {
+			return handler(srv, ss)
+		}
+		log.Printf("[Fault Interceptor] %s: delay %v, code %v, abort after %d messages",
+			info.FullMethod, time.Duration(fault.Delay), fault.Code, fault.AbortAfter)
+		fault.delay(ss.Context())
+		if fault.AbortAfter <= 0 {
+			if fault.Code != codes.OK {
+				return fault.err(info.FullMethod)
+			}
+			return handler(srv, ss)
+		}
+		stream := &faultyStream{ServerStream: ss, limit: fault.AbortAfter, abort: fault.err(info.FullMethod)}
+		err := handler(srv, stream)
+		if stream.aborted() {
+			// the handler may have swallowed the error, the client still has to see it
+			return stream.abort
+		}
+		return err
+	}
+}
+
+// faultyStream lets a number of messages through each way and fails every call after
+// them in either direction.
+type faultyStream struct {
+	grpc.ServerStream
+	abort error
+	limit int
+
+	mu       sync.Mutex
+	sent     int
+	received int
+	tripped  bool
+}
+
+// take counts one more message in the direction of count, or trips the stream when the
+// limit is already reached.
+func (s *faultyStream) take(count *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tripped || *count >= s.limit {
+		s.tripped = true
+		return s.abort
+	}
+	*count++
+	return nil
+}
+
+func (s *faultyStream) aborted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tripped
+}
+
+func (s *faultyStream) SendMsg(m interface{}) error {
+	if err := s.take(&s.sent); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *faultyStream) RecvMsg(m interface{}) error {
+	if s.aborted() {
+		return s.abort
+	}
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	// only a message that arrived counts, the io.EOF of a client done sending does not
+	return s.take(&s.received)
+}
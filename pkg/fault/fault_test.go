@@ -0,0 +1,181 @@
+package fault
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/jsonconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const method = "/proto.OrderManagement/getOrder"
+
+func TestMain(m *testing.M) {
+	// every injected fault is logged
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func injector(fault Fault) *Injector {
+	return NewInjector(Config{Methods: map[string]Fault{method: fault}})
+}
+
+// unary calls the injector's unary interceptor and reports whether the handler ran.
+func unary(ctx context.Context, f *Injector) (bool, error) {
+	called := false
+	_, err := f.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	return called, err
+}
+
+func TestInjectError(t *testing.T) {
+	tests := []struct {
+		name   string
+		fault  Fault
+		want   codes.Code
+		called bool
+	}{
+		{"every call", Fault{Percentage: 100, Code: codes.Unavailable}, codes.Unavailable, false},
+		{"no call", Fault{Percentage: 0, Code: codes.Unavailable}, codes.OK, true},
+		{"delay only", Fault{Percentage: 100}, codes.OK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called, err := unary(context.Background(), injector(tt.fault))
+			if status.Code(err) != tt.want || called != tt.called {
+				t.Errorf("got %v with the handler called %v, want %v and %v", err, called, tt.want, tt.called)
+			}
+		})
+	}
+}
+
+func TestInjectDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	f := injector(Fault{Percentage: 100, Delay: jsonconfig.Duration(delay)})
+
+	start := time.Now()
+	if called, err := unary(context.Background(), f); err != nil || !called {
+		t.Fatalf("got %v with the handler called %v, want the handler to run", err, called)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("the call took %v, want at least the %v delay", elapsed, delay)
+	}
+
+	// a caller that gives up ends the wait
+	ctx, cancel := context.WithTimeout(context.Background(), delay/4)
+	defer cancel()
+	start = time.Now()
+	unary(ctx, f)
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("a cancelled call waited %v, want it to stop with its context", elapsed)
+	}
+}
+
+func TestFailAttempts(t *testing.T) {
+	f := injector(Fault{FailAttempts: 2, Code: codes.Unavailable})
+	for previous, want := range []codes.Code{codes.Unavailable, codes.Unavailable, codes.OK, codes.OK} {
+		ctx := context.Background()
+		if previous > 0 {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(previousAttemptsKey, strconv.Itoa(previous)))
+		}
+		if _, err := unary(ctx, f); status.Code(err) != want {
+			t.Errorf("attempt after %d: %v, want %v", previous, err, want)
+		}
+	}
+}
+
+func TestMetadataFaults(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(faultCodeKey, "UNAVAILABLE"))
+	for _, allowed := range []bool{false, true} {
+		want := codes.OK
+		if allowed {
+			want = codes.Unavailable
+		}
+		f := NewInjector(Config{Metadata: allowed})
+		if _, err := unary(ctx, f); status.Code(err) != want {
+			t.Errorf("metadata allowed %v: %v, want %v", allowed, err, want)
+		}
+	}
+}
+
+// clientStream delivers n messages, then io.EOF, and drops what the handler sends.
+type clientStream struct {
+	grpc.ServerStream
+	n int
+}
+
+func (s *clientStream) Context() context.Context { return context.Background() }
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	if s.n == 0 {
+		return io.EOF
+	}
+	s.n--
+	return nil
+}
+
+func (s *clientStream) SendMsg(m interface{}) error { return nil }
+
+func TestAbortAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		sent int
+		want codes.Code
+	}{
+		{"fewer messages", 1, codes.OK},
+		{"exactly as many messages", 2, codes.OK},
+		{"more messages", 3, codes.Aborted},
+	}
+	f := injector(Fault{Percentage: 100, AbortAfter: 2})
+	info := &grpc.StreamServerInfo{FullMethod: method, IsClientStream: true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := 0
+			err := f.StreamServerInterceptor()(nil, &clientStream{n: tt.sent}, info, func(srv interface{}, ss grpc.ServerStream) error {
+				for {
+					if err := ss.RecvMsg(&wrapperspb.StringValue{}); err == io.EOF {
+						return ss.SendMsg(wrapperspb.Int64(int64(received)))
+					} else if err != nil {
+						// a handler that swallows the error does not save the stream
+						return nil
+					}
+					received++
+				}
+			})
+			if status.Code(err) != tt.want {
+				t.Errorf("stream of %d messages: %v, want %v", tt.sent, err, tt.want)
+			}
+			if received > 2 {
+				t.Errorf("the handler received %d messages, want at most 2", received)
+			}
+		})
+	}
+}
+
+func TestAbortAfterSends(t *testing.T) {
+	f := injector(Fault{Percentage: 100, AbortAfter: 2})
+	info := &grpc.StreamServerInfo{FullMethod: method, IsServerStream: true}
+	sent := 0
+	err := f.StreamServerInterceptor()(nil, &clientStream{}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			if err := ss.SendMsg(wrapperspb.Int64(int64(i))); err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	if status.Code(err) != codes.Aborted || sent != 2 {
+		t.Errorf("sent %d of 3 messages and ended with %v, want 2 and Aborted", sent, err)
+	}
+}
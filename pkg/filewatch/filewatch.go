@@ -0,0 +1,55 @@
+// Package filewatch reloads configuration and certificate files of the training servers
+// when they change on disk.
+package filewatch
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Watch polls paths every interval and calls reload whenever the latest modification time
+// among them moves away from the one seen last, until stop is closed. Empty paths are
+// skipped. When reload fails the previous version stays in force and the next change is
+// tried again.
+func Watch(interval time.Duration, stop <-chan struct{}, reload func() error, paths ...string) {
+	last := latestModTime(paths)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modTime := latestModTime(paths)
+			if modTime.IsZero() || modTime.Equal(last) {
+				continue
+			}
+			if err := reload(); err != nil {
+				log.Printf("keep the previous version, reload %v failed: %v", paths, err)
+				continue
+			}
+			last = modTime
+			log.Printf("%v reloaded", paths)
+		}
+	}
+}
+
+func latestModTime(paths []string) time.Time {
+	var latest time.Time
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("watch %s: %v", path, err)
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
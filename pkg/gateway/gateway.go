@@ -0,0 +1,251 @@
+// Package gateway serves HTTP/JSON endpoints and gRPC-Web of the training servers next to
+// gRPC, on the same port, for consumers that cannot speak gRPC. The endpoints call the gRPC
+// server over an in-process connection, and gRPC-Web requests are handed to the gRPC server
+// itself, so every call still runs through its interceptors.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/kekeee-shine/grpc_training/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// bufSize is the buffer of the in-process connection in each direction.
+const bufSize = 1 << 20
+
+// Config is the HTTP side of a server.
+type Config struct {
+	Enabled bool
+	// WebOrigins are the origins of the pages that may call gRPC-Web, "*" for any. Pages
+	// served from the server's own origin need not be listed.
+	WebOrigins []string
+}
+
+// Flags registers -gateway and -grpcweb_origins on the command line.
+func Flags() *Config {
+	c := &Config{}
+	flag.BoolVar(&c.Enabled, "gateway", false, "also serve the HTTP/JSON gateway and gRPC-Web on the gRPC port, needs a server without TLS")
+	flag.Func("grpcweb_origins", "comma-separated origins of the pages that may call gRPC-Web, * for any", func(value string) error {
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.WebOrigins = append(c.WebOrigins, origin)
+			}
+		}
+		return nil
+	})
+	return c
+}
+
+func (c *Config) allowOrigin(origin string) bool {
+	for _, allowed := range c.WebOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Server is the HTTP side of a listener shared with a gRPC server.
+type Server struct {
+	http *http.Server
+	conn *grpc.ClientConn
+}
+
+// Serve splits lis with Split and serves on its HTTP connections gRPC-Web, in binary and
+// text mode, for the unary and server streaming methods of s, and the handler that routes
+// returns for a connection to s for everything else. s is to be served on the returned
+// listener.
+func (c *Config) Serve(s *grpc.Server, lis net.Listener, routes func(conn *grpc.ClientConn) http.Handler) (net.Listener, *Server, error) {
+	conn, err := Dial(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	web := grpcweb.WrapServer(s, grpcweb.WithOriginFunc(c.allowOrigin))
+	rest := routes(conn)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CORS preflights of gRPC-Web are answered for the methods of s only
+		if web.IsGrpcWebRequest(r) || web.IsAcceptableGrpcCorsRequest(r) {
+			web.ServeHTTP(w, r)
+			return
+		}
+		rest.ServeHTTP(w, r)
+	})
+
+	grpcLis, httpLis := Split(lis)
+	g := &Server{http: &http.Server{Handler: handler}, conn: conn}
+	go func() {
+		// stopping the gRPC server closes httpLis as well
+		err := g.http.Serve(httpLis)
+		if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			log.Printf("gateway: stopped serving HTTP: %v", err)
+		}
+	}()
+	return grpcLis, g, nil
+}
+
+// Shutdown waits for the running HTTP requests until ctx ends, and closes the idle
+// connections. Stopping the gRPC server already stops accepting new ones.
+func (g *Server) Shutdown(ctx context.Context) error {
+	defer g.conn.Close()
+	return g.http.Shutdown(ctx)
+}
+
+// Dial serves s on an in-process listener as well and returns a connection to it. The
+// listener closes, like the others of s, when s stops. s must not use TLS credentials.
+func Dial(s *grpc.Server) (*grpc.ClientConn, error) {
+	lis := bufconn.Listen(bufSize)
+	go s.Serve(lis)
+	return grpc.Dial("gateway",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+}
+
+// Context returns the context of the gRPC call for r. It forwards the Authorization header,
+// and the IP address of the HTTP client, which identity.Caller takes from in-process callers
+// like the gateway only.
+func Context(r *http.Request) context.Context {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	md := metadata.Pairs(identity.ForwardedForKey, host)
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		md.Set("authorization", auth)
+	}
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// ReadMessage parses the JSON body of r into m.
+func ReadMessage(r *http.Request, m proto.Message) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to read body: %v", err)
+	}
+	if err := protojson.Unmarshal(body, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid body: %v", err)
+	}
+	return nil
+}
+
+// WriteMessage writes m as a JSON response.
+func WriteMessage(w http.ResponseWriter, m proto.Message) {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
+
+// WriteError writes the status of err as a JSON google.rpc.Status, with the HTTP status
+// code closest to its gRPC code.
+func WriteError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	b, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		log.Printf("gateway: failed to marshal status %v: %v", st, err)
+		b = []byte(`{"code":13}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(HTTPStatus(st.Code()))
+	w.Write(append(b, '\n'))
+}
+
+// HTTPStatus maps a gRPC code to an HTTP status code.
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Stream writes a server stream as newline-delimited JSON, one message per line, flushed
+// as it comes. An error after the first message can no longer change the HTTP status, so
+// it ends the stream as a last line {"error": <google.rpc.Status>}.
+type Stream struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func NewStream(w http.ResponseWriter) *Stream {
+	return &Stream{w: w}
+}
+
+// Send writes m as the next line.
+func (s *Stream) Send(m proto.Message) error {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if !s.started {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+		s.started = true
+	}
+	if _, err := s.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// Close ends the stream with err, if any.
+func (s *Stream) Close(err error) {
+	if err == nil {
+		if !s.started {
+			s.w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		return
+	}
+	if !s.started {
+		WriteError(s.w, err)
+		return
+	}
+	b, merr := protojson.Marshal(status.Convert(err).Proto())
+	if merr != nil {
+		log.Printf("gateway: failed to marshal status of %v: %v", err, merr)
+		return
+	}
+	s.w.Write([]byte(`{"error":` + string(b) + "}\n"))
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// sniffTimeout bounds how long a new connection may take to send its first bytes.
+const sniffTimeout = time.Second * 10
+
+// Split shares lis between a gRPC server and an HTTP server. A connection goes to grpcLis
+// when it starts with the HTTP/2 client preface ("PRI * HTTP/2.0...") or a TLS handshake,
+// and to httpLis otherwise, which is HTTP/1.1 in practice. Closing either listener closes
+// lis and both of them.
+func Split(lis net.Listener) (grpcLis, httpLis net.Listener) {
+	sp := &splitter{lis: lis, closed: make(chan struct{})}
+	g := &subListener{splitter: sp, conns: make(chan net.Conn)}
+	h := &subListener{splitter: sp, conns: make(chan net.Conn)}
+	go sp.serve(g, h)
+	return g, h
+}
+
+type splitter struct {
+	lis    net.Listener
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (sp *splitter) close() error {
+	var err error
+	sp.once.Do(func() {
+		close(sp.closed)
+		err = sp.lis.Close()
+	})
+	return err
+}
+
+func (sp *splitter) serve(grpcLis, httpLis *subListener) {
+	for {
+		conn, err := sp.lis.Accept()
+		if err != nil {
+			sp.close()
+			return
+		}
+		// a slow client must not hold up the connections behind it
+		go sp.route(conn, grpcLis, httpLis)
+	}
+}
+
+func (sp *splitter) route(conn net.Conn, grpcLis, httpLis *subListener) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := r.Peek(3)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	target := httpLis
+	// 0x16 starts a TLS handshake record
+	if string(first) == "PRI" || first[0] == 0x16 {
+		target = grpcLis
+	}
+	select {
+	case target.conns <- &peekedConn{Conn: conn, r: r}:
+	case <-sp.closed:
+		conn.Close()
+	}
+}
+
+type subListener struct {
+	*splitter
+	conns chan net.Conn
+}
+
+func (l *subListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *subListener) Close() error {
+	return l.close()
+}
+
+func (l *subListener) Addr() net.Addr {
+	return l.lis.Addr()
+}
+
+// peekedConn replays the bytes read while sniffing before the rest of the connection.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
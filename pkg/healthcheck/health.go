@@ -0,0 +1,111 @@
+// Package healthcheck exposes the standard grpc.health.v1.Health service of the training
+// servers, with a status per service.
+package healthcheck
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// probeTimeout bounds a single probe of Monitor.
+	probeTimeout = time.Second * 5
+	// watchGrace is how long the watchers get to receive NOT_SERVING before their
+	// streams are ended on shutdown.
+	watchGrace = time.Millisecond * 100
+)
+
+// Server is the health service of grpc-go, except that Shutdown also ends the Watch streams:
+// they never finish on their own and would hold a graceful stop until its drain timeout.
+type Server struct {
+	*health.Server
+	stopping chan struct{}
+	once     sync.Once
+}
+
+// Shutdown reports every service as NOT_SERVING, for good, and ends the Watch streams.
+func (s *Server) Shutdown() {
+	s.Server.Shutdown()
+	s.once.Do(func() { close(s.stopping) })
+}
+
+// Watch implements healthpb.HealthServer
+func (s *Server) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopping:
+			time.Sleep(watchGrace)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := s.Server.Watch(req, &watchStream{Health_WatchServer: stream, ctx: ctx})
+	if ctx.Err() != nil && stream.Context().Err() == nil {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	return err
+}
+
+type watchStream struct {
+	healthpb.Health_WatchServer
+	ctx context.Context
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+// Register registers the health service on s and reports SERVING for the server as a
+// whole ("") and for every service already registered on s, by its full name, e.g.
+// proto.OrderManagement. Clients Check a status once or Watch it for changes.
+// SetServingStatus on the returned server flips the status of a service.
+func Register(s *grpc.Server) *Server {
+	hs := &Server{Server: health.NewServer(), stopping: make(chan struct{})}
+	for name := range s.GetServiceInfo() {
+		hs.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	healthpb.RegisterHealthServer(s, hs)
+	return hs
+}
+
+// Probe checks a dependency of a service, e.g. its store, and fails while it is unusable.
+type Probe func(ctx context.Context) error
+
+// Monitor runs probe every interval until stop is closed, and reports service as
+// NOT_SERVING while the probe fails and SERVING again once it passes.
+func Monitor(hs *Server, service string, probe Probe, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	serving := true
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		err := probe(ctx)
+		cancel()
+
+		switch {
+		case err != nil && serving:
+			log.Printf("health: %s is NOT_SERVING: %v", service, err)
+			hs.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+			serving = false
+		case err == nil && !serving:
+			log.Printf("health: %s is SERVING again", service)
+			hs.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+			serving = true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
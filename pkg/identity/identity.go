@@ -0,0 +1,52 @@
+// Package identity names the caller of an RPC for rate limits and audit records. Only what
+// the server verified counts: a name the caller declares about itself in metadata is never
+// used, or a client could pick a new one whenever it likes.
+package identity
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/tlsutil"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+)
+
+// ForwardedForKey is the metadata key in which a proxy in the same process, like the HTTP
+// gateway, names the IP address of the client it calls for. It is ignored on calls from
+// other processes, where any client could set it.
+const ForwardedForKey = "x-forwarded-for"
+
+type subjectKey struct{}
+
+// WithSubject records the subject of the bearer token the current RPC was authenticated with.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// Caller names the caller by, in order: the verified TLS certificate, the subject of its
+// verified bearer token, and finally its IP address, the forwarded one for in-process callers.
+// All unauthenticated callers over a Unix socket are the one caller "unix:local".
+func Caller(ctx context.Context) string {
+	if id := tlsutil.PeerIdentity(ctx); id != "" {
+		return "cert:" + id
+	}
+	if subject, ok := ctx.Value(subjectKey{}).(string); ok && subject != "" {
+		return "sub:" + subject
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr.Network() == "bufconn" {
+			if md, _ := metadata.FromIncomingContext(ctx); len(md.Get(ForwardedForKey)) > 0 {
+				return "ip:" + md.Get(ForwardedForKey)[0]
+			}
+		}
+		if p.Addr.Network() == "unix" {
+			// the peers of a Unix socket are local processes without an address
+			return "unix:local"
+		}
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "ip:" + host
+		}
+		return "ip:" + p.Addr.String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,40 @@
+package identity
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+type inProcessAddr struct{}
+
+func (inProcessAddr) Network() string { return "bufconn" }
+func (inProcessAddr) String() string  { return "bufconn" }
+
+func TestCaller(t *testing.T) {
+	tcp := &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 53211}
+	forwarded := metadata.Pairs(ForwardedForKey, "192.0.2.1")
+
+	tests := []struct {
+		name string
+		addr net.Addr
+		md   metadata.MD
+		want string
+	}{
+		{"tcp peer", tcp, nil, "ip:10.0.0.7"},
+		{"tcp peer naming another address", tcp, forwarded, "ip:10.0.0.7"},
+		{"in-process proxy", inProcessAddr{}, forwarded, "ip:192.0.2.1"},
+		{"unix socket peer", &net.UnixAddr{Net: "unix"}, nil, "unix:local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: tt.addr})
+			ctx = metadata.NewIncomingContext(ctx, tt.md)
+			if got := Caller(ctx); got != tt.want {
+				t.Errorf("Caller = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Package jsonconfig reads the JSON configuration files of the training servers.
+package jsonconfig
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration written as "8s" or "250ms" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = Duration(parsed)
+	return err
+}
+
+// Load decodes the JSON file at path into v.
+func Load(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,81 @@
+// Package shutdown stops the training servers gracefully on SIGINT and SIGTERM.
+package shutdown
+
+import (
+	"flag"
+	"google.golang.org/grpc"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Health is the health service of a server, told when the server goes down,
+// e.g. a *health.Server.
+type Health interface {
+	Shutdown()
+}
+
+// Config bounds how long a stopping server waits for its in-flight RPCs. NotServingDelay
+// is how long the server keeps taking new RPCs after it reported NOT_SERVING, for health
+// checking clients and load balancers to see it and move to other backends first.
+type Config struct {
+	NotServingDelay time.Duration
+	DrainTimeout    time.Duration
+}
+
+// Flags registers -not_serving_delay and -drain_timeout on the command line.
+func Flags() *Config {
+	c := &Config{}
+	flag.DurationVar(&c.NotServingDelay, "not_serving_delay", time.Second*2, "how long new RPCs are still taken after health turns NOT_SERVING")
+	flag.DurationVar(&c.DrainTimeout, "drain_timeout", time.Second*30, "how long in-flight RPCs may run after SIGINT or SIGTERM")
+	return c
+}
+
+// Serve serves s on lis until the process gets SIGINT or SIGTERM. Then it reports every
+// service of hs, if set, as NOT_SERVING, waits NotServingDelay, stops accepting connections
+// and RPCs, and waits up to DrainTimeout for the running RPCs before it cancels them.
+func (c *Config) Serve(s *grpc.Server, lis net.Listener, hs Health) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-signals:
+		log.Printf("received %v, draining for up to %v after %v", sig, c.DrainTimeout, c.NotServingDelay)
+	}
+	c.Stop(s, hs)
+	return <-served
+}
+
+// Stop is the shutdown of Serve, for servers that are stopped by other means than a signal.
+func (c *Config) Stop(s *grpc.Server, hs Health) {
+	if hs != nil {
+		// load balancers and health checking clients move to other backends first
+		hs.Shutdown()
+		time.Sleep(c.NotServingDelay)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(drained)
+	}()
+	timer := time.NewTimer(c.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		log.Printf("all RPCs finished, server stopped")
+	case <-timer.C:
+		log.Printf("drain timeout %v reached, cancelling the remaining RPCs", c.DrainTimeout)
+		s.Stop()
+		<-drained
+	}
+}
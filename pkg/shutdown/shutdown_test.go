@@ -0,0 +1,123 @@
+package shutdown
+
+import (
+	"context"
+	"github.com/kekeee-shine/grpc_training/pkg/healthcheck"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// collectDesc is a client stream that answers with the number of messages it received,
+// the way UpdateOrders answers with the orders of its batch.
+var collectDesc = grpc.ServiceDesc{
+	ServiceName: "test.Drain",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Collect",
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			var n int64
+			for {
+				if err := stream.RecvMsg(&wrapperspb.StringValue{}); err == io.EOF {
+					return stream.SendMsg(wrapperspb.Int64(n))
+				} else if err != nil {
+					return err
+				}
+				n++
+			}
+		},
+	}},
+}
+
+func TestStopDrainsOpenStreams(t *testing.T) {
+	const notServingDelay = time.Millisecond * 300
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	s.RegisterService(&collectDesc, struct{}{})
+	hs := healthcheck.Register(s)
+	go s.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	health := healthpb.NewHealthClient(conn)
+
+	watch, err := health.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("watch = %v, %v, want SERVING", resp, err)
+	}
+	stream, err := conn.NewStream(context.Background(), &collectDesc.Streams[0], "/test.Drain/Collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(wrapperspb.String("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	start := time.Now()
+	go func() {
+		(&Config{NotServingDelay: notServingDelay, DrainTimeout: time.Second * 5}).Stop(s, hs)
+		close(stopped)
+	}()
+
+	// the watcher hears NOT_SERVING while the server still takes new calls, and new calls
+	// are refused once the delay is over and the client heard of the stop
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("watch = %v, %v, want NOT_SERVING", resp, err)
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := health.Check(ctx, &healthpb.HealthCheckRequest{})
+		cancel()
+		if err != nil {
+			if time.Since(start) < notServingDelay {
+				t.Errorf("a new call was refused %v into the %v delay: %v", time.Since(start), notServingDelay, err)
+			}
+			break
+		}
+		if time.Since(start) > notServingDelay+time.Second {
+			t.Fatal("new calls are still accepted a second after the delay")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// the open stream completes with every message it sent
+	if err := stream.SendMsg(wrapperspb.String("2")); err != nil {
+		t.Fatalf("the open stream was cut: %v", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	n := &wrapperspb.Int64Value{}
+	if err := stream.RecvMsg(n); err != nil {
+		t.Fatalf("the open stream was cut: %v", err)
+	}
+	if n.Value != 2 {
+		t.Errorf("the open stream delivered %d messages, want 2", n.Value)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 2):
+		t.Fatal("the server did not stop after its last RPC")
+	}
+	if elapsed := time.Since(start); elapsed < notServingDelay {
+		t.Errorf("the server stopped %v after NOT_SERVING, before the %v delay", elapsed, notServingDelay)
+	}
+}
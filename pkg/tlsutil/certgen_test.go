@@ -0,0 +1,51 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteDevCertsKeepsCA(t *testing.T) {
+	dir := t.TempDir()
+	if err := WriteDevCerts(dir, "demo-client", "reader"); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := loadPool(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(filepath.Join(dir, "server.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second run rotates the certificates, the pool loaded before must still trust them
+	if err := WriteDevCerts(dir, "demo-client", "reader"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"server.pem", "client.pem"} {
+		r, err := NewReloader(filepath.Join(dir, name), filepath.Join(dir, name[:len(name)-4]+"-key.pem"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := r.current()
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+		if _, err := leaf.Verify(opts); err != nil {
+			t.Errorf("rotated %s is not trusted by the CA loaded before: %v", name, err)
+		}
+	}
+	second, err := os.ReadFile(filepath.Join(dir, "server.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("server.pem was not rotated")
+	}
+}
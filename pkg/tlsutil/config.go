@@ -0,0 +1,123 @@
+// Package tlsutil configures TLS and mutual TLS for the training servers and clients.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"os"
+	"time"
+)
+
+// reloadInterval is how often the certificate files are checked for changes.
+const reloadInterval = time.Second * 10
+
+// ServerConfig selects the server transport: plaintext when CertFile is empty,
+// TLS otherwise, and mutual TLS when ClientCAFile is set as well.
+type ServerConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// ServerFlags registers -tls_cert, -tls_key and -tls_client_ca on the command line.
+func ServerFlags() *ServerConfig {
+	c := &ServerConfig{}
+	flag.StringVar(&c.CertFile, "tls_cert", "", "server certificate (PEM), enables TLS")
+	flag.StringVar(&c.KeyFile, "tls_key", "", "server private key (PEM)")
+	flag.StringVar(&c.ClientCAFile, "tls_client_ca", "", "CA that signs client certificates (PEM), enables mutual TLS")
+	return c
+}
+
+// ServerOption returns the grpc.Creds option for the configured transport. The certificate,
+// key and client CA are re-read whenever the files change, without restarting the server.
+func (c *ServerConfig) ServerOption() (grpc.ServerOption, error) {
+	if c.CertFile == "" {
+		return grpc.EmptyServerOption{}, nil
+	}
+	r, err := NewReloader(c.CertFile, c.KeyFile, c.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	go r.Watch(reloadInterval, nil)
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				config.ClientCAs = pool
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+	return grpc.Creds(credentials.NewTLS(config)), nil
+}
+
+// ClientConfig selects the client transport: plaintext when CAFile is empty, TLS verified
+// against CAFile otherwise, presenting CertFile/KeyFile to the server when they are set.
+type ClientConfig struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// ClientFlags registers -tls_ca, -tls_cert, -tls_key and -tls_server_name on the command line.
+func ClientFlags() *ClientConfig {
+	c := &ClientConfig{}
+	flag.StringVar(&c.CAFile, "tls_ca", "", "CA that signs the server certificate (PEM), enables TLS")
+	flag.StringVar(&c.CertFile, "tls_cert", "", "client certificate (PEM) for mutual TLS")
+	flag.StringVar(&c.KeyFile, "tls_key", "", "client private key (PEM) for mutual TLS")
+	flag.StringVar(&c.ServerName, "tls_server_name", "localhost", "name expected in the server certificate")
+	return c
+}
+
+// DialOption returns the transport credentials for the configured transport.
+// The client certificate is re-read whenever its files change.
+func (c *ClientConfig) DialOption() (grpc.DialOption, error) {
+	if c.CAFile == "" {
+		return grpc.WithInsecure(), nil
+	}
+	pool, err := loadPool(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		ServerName: c.ServerName,
+	}
+	if c.CertFile != "" {
+		r, err := NewReloader(c.CertFile, c.KeyFile, "")
+		if err != nil {
+			return nil, err
+		}
+		go r.Watch(reloadInterval, nil)
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config)), nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,31 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/x509"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerCertificate returns the verified client certificate of the current RPC, taken from
+// the peer.Peer that gRPC puts into every handler's context. It reports false for
+// plaintext connections and for TLS connections without a client certificate.
+func PeerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return tlsInfo.State.VerifiedChains[0][0], true
+}
+
+// PeerIdentity returns the common name of the verified client certificate, or "".
+func PeerIdentity(ctx context.Context) string {
+	if cert, ok := PeerCertificate(ctx); ok {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
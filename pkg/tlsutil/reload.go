@@ -0,0 +1,59 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/kekeee-shine/grpc_training/pkg/filewatch"
+	"sync"
+	"time"
+)
+
+// Reloader keeps a certificate, and optionally a CA pool, in sync with their files so
+// that renewed certificates are picked up by the next handshake.
+type Reloader struct {
+	certFile, keyFile, caFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// NewReloader loads certFile/keyFile and, if caFile is not empty, the CA pool.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. The loaded certificate is kept if the new files are invalid.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		if pool, err = loadPool(r.caFile); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool = &cert, pool
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the files whenever one of them is modified, checking every interval
+// until stop is closed.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	filewatch.Watch(interval, stop, r.Reload, r.certFile, r.keyFile, r.caFile)
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
@@ -0,0 +1,206 @@
+package tracing
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// UnaryServerInterceptor Server :: Unary Interceptor
+// continues the caller's trace with a server span around the handler
+func (t *Tracer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := t.startServerSpan(ctx, info.FullMethod)
+		m, err := handler(ctx, req)
+		span.SetStatus(status.Code(err).String())
+		span.End()
+		return m, err
+	}
+}
+
+// StreamServerInterceptor Server :: Stream Interceptor
+// continues the caller's trace with a server span around the handler, and a child span
+// for every message sent or received
+func (t *Tracer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := t.startServerSpan(ss.Context(), info.FullMethod)
+		err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx, messages: messageTracer{tracer: t, method: info.FullMethod, ctx: ctx}})
+		span.SetStatus(status.Code(err).String())
+		span.End()
+		return err
+	}
+}
+
+func (t *Tracer) startServerSpan(ctx context.Context, fullMethod string) (context.Context, *Span) {
+	ctx, span := t.Start(Extract(ctx), fullMethod, KindServer)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", fullMethod)
+	if p, ok := peer.FromContext(ctx); ok {
+		span.SetAttribute("net.peer", p.Addr.String())
+	}
+	return ctx, span
+}
+
+// UnaryClientInterceptor Client :: Unary Interceptor
+// wraps the call in a client span and sends its trace context along in the metadata
+func (t *Tracer) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := t.startClientSpan(ctx, method, cc)
+		err := invoker(Inject(ctx), method, req, reply, cc, opts...)
+		span.SetStatus(status.Code(err).String())
+		span.End()
+		return err
+	}
+}
+
+// StreamClientInterceptor Client :: Stream Interceptor
+// wraps the stream in a client span that ends with the stream, and a child span for every
+// message sent or received. A stream the caller neither finishes nor cancels keeps its span open.
+func (t *Tracer) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := t.startClientSpan(ctx, method, cc)
+		cs, err := streamer(Inject(ctx), desc, cc, method, opts...)
+		if err != nil {
+			span.SetStatus(status.Code(err).String())
+			span.End()
+			return nil, err
+		}
+
+		s := &tracedClientStream{
+			ClientStream:  cs,
+			span:          span,
+			serverStreams: desc.ServerStreams,
+			done:          make(chan struct{}),
+			messages:      messageTracer{tracer: t, method: method, ctx: ctx},
+		}
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.finish(ctx.Err())
+			case <-s.done:
+			}
+		}()
+		return s, nil
+	}
+}
+
+func (t *Tracer) startClientSpan(ctx context.Context, method string, cc *grpc.ClientConn) (context.Context, *Span) {
+	ctx, span := t.Start(ctx, method, KindClient)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+	span.SetAttribute("net.peer", cc.Target())
+	return ctx, span
+}
+
+// messageTracer starts a span for every message of one stream.
+type messageTracer struct {
+	tracer *Tracer
+	method string
+	ctx    context.Context
+
+	mu       sync.Mutex
+	sent     int
+	received int
+}
+
+func (mt *messageTracer) trace(direction string, m interface{}, call func() error) error {
+	mt.mu.Lock()
+	var seq int
+	if direction == "send" {
+		mt.sent++
+		seq = mt.sent
+	} else {
+		mt.received++
+		seq = mt.received
+	}
+	mt.mu.Unlock()
+
+	_, span := mt.tracer.Start(mt.ctx, mt.method+"/"+direction, KindInternal)
+	span.SetAttribute("message.seq", strconv.Itoa(seq))
+	err := call()
+	if msg, ok := m.(proto.Message); ok && err == nil {
+		span.SetAttribute("message.size", strconv.Itoa(proto.Size(msg)))
+	}
+	if err != nil {
+		span.SetStatus(endOfStream(err))
+	}
+	span.End()
+	return err
+}
+
+// endOfStream names io.EOF, which only marks the regular end of a stream, apart from the status codes.
+func endOfStream(err error) string {
+	if err == io.EOF {
+		return "EOF"
+	}
+	return status.Code(err).String()
+}
+
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages messageTracer
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *tracedServerStream) SendMsg(m interface{}) error {
+	return s.messages.trace("send", m, func() error { return s.ServerStream.SendMsg(m) })
+}
+
+func (s *tracedServerStream) RecvMsg(m interface{}) error {
+	return s.messages.trace("recv", m, func() error { return s.ServerStream.RecvMsg(m) })
+}
+
+type tracedClientStream struct {
+	grpc.ClientStream
+	span          *Span
+	serverStreams bool
+	done          chan struct{}
+	once          sync.Once
+	messages      messageTracer
+}
+
+func (s *tracedClientStream) SendMsg(m interface{}) error {
+	err := s.messages.trace("send", m, func() error { return s.ClientStream.SendMsg(m) })
+	if err != nil && err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.messages.trace("recv", m, func() error { return s.ClientStream.RecvMsg(m) })
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.serverStreams:
+		// the single response of a unary or client streaming call ends the stream
+		s.finish(nil)
+	}
+	return err
+}
+
+func (s *tracedClientStream) finish(err error) {
+	s.once.Do(func() {
+		code := status.Code(err)
+		if err == context.Canceled {
+			code = codes.Canceled
+		} else if err == context.DeadlineExceeded {
+			code = codes.DeadlineExceeded
+		}
+		s.span.SetStatus(code.String())
+		s.span.End()
+		close(s.done)
+	})
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net"
+	"testing"
+)
+
+// echoDesc sends every message back, from a unary call and on a bidirectional stream.
+var echoDesc = grpc.ServiceDesc{
+	ServiceName: "test.Trace",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &wrapperspb.StringValue{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{FullMethod: "/test.Trace/Echo"}, handler)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "EchoStream",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			for {
+				m := &wrapperspb.StringValue{}
+				if err := stream.RecvMsg(m); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := stream.SendMsg(m); err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+// findSpan returns the span of the given name and kind.
+func findSpan(t *testing.T, e *InMemoryExporter, name string, kind SpanKind) *Span {
+	t.Helper()
+	for _, span := range e.Spans() {
+		if span.Name == name && span.Kind == kind {
+			return span
+		}
+	}
+	t.Fatalf("no %s span %s among %d spans", kind, name, len(e.Spans()))
+	return nil
+}
+
+func TestTraceContextCrossesCalls(t *testing.T) {
+	serverSpans, clientSpans := NewInMemoryExporter(), NewInMemoryExporter()
+	serverTracer, clientTracer := NewTracer(serverSpans), NewTracer(clientSpans)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(serverTracer.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(serverTracer.StreamServerInterceptor()))
+	s.RegisterService(&echoDesc, struct{}{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(clientTracer.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(clientTracer.StreamClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		method string
+		call   func(ctx context.Context) error
+	}{
+		{"/test.Trace/Echo", func(ctx context.Context) error {
+			return conn.Invoke(ctx, "/test.Trace/Echo", wrapperspb.String("ping"), &wrapperspb.StringValue{})
+		}},
+		{"/test.Trace/EchoStream", func(ctx context.Context) error {
+			stream, err := conn.NewStream(ctx, &echoDesc.Streams[0], "/test.Trace/EchoStream")
+			if err != nil {
+				return err
+			}
+			if err := stream.SendMsg(wrapperspb.String("ping")); err != nil {
+				return err
+			}
+			if err := stream.CloseSend(); err != nil {
+				return err
+			}
+			for {
+				if err := stream.RecvMsg(&wrapperspb.StringValue{}); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			serverSpans.Reset()
+			clientSpans.Reset()
+			ctx, root := clientTracer.Start(context.Background(), "request", KindInternal)
+			if err := tt.call(ctx); err != nil {
+				t.Fatal(err)
+			}
+			root.End()
+
+			client := findSpan(t, clientSpans, tt.method, KindClient)
+			server := findSpan(t, serverSpans, tt.method, KindServer)
+			if client.TraceID != root.TraceID || client.ParentSpanID != root.SpanID {
+				t.Errorf("client span is in trace %s under %s, want trace %s under %s", client.TraceID, client.ParentSpanID, root.TraceID, root.SpanID)
+			}
+			if server.TraceID != client.TraceID || server.ParentSpanID != client.SpanID {
+				t.Errorf("server span is in trace %s under %s, want trace %s under %s", server.TraceID, server.ParentSpanID, client.TraceID, client.SpanID)
+			}
+			for _, span := range serverSpans.Spans() {
+				if span.Kind == KindInternal && span.ParentSpanID != server.SpanID {
+					t.Errorf("message span %s is under %s, want the server span %s", span.Name, span.ParentSpanID, server.SpanID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+// traceparentKey is the W3C Trace Context header, https://www.w3.org/TR/trace-context/
+const traceparentKey = "traceparent"
+
+// FormatTraceparent renders sc as "00-<trace id>-<span id>-<flags>".
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent is the inverse of FormatTraceparent. Like the spec asks, it takes the
+// first four fields of a traceparent of a later version and ignores the rest, while a
+// version 00 traceparent must have exactly four.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || !isLowerHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("malformed traceparent %q", value)
+	}
+	if !isLowerHex(parts[1], 32) || !isLowerHex(parts[2], 16) || !isLowerHex(parts[3], 2) {
+		return sc, fmt.Errorf("malformed traceparent %q", value)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("malformed trace id in %q", value)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("malformed span id in %q", value)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("malformed flags in %q", value)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("all zero ids in %q", value)
+	}
+	return sc, nil
+}
+
+// isLowerHex reports whether s is n lowercase hex digits, the only form the spec allows.
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Inject adds the trace context of the span in ctx to the outgoing gRPC metadata.
+func Inject(ctx context.Context) context.Context {
+	span, ok := SpanFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(traceparentKey, FormatTraceparent(span.SpanContext()))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// Extract makes the trace context of the incoming gRPC metadata the remote parent of
+// the spans started from the returned context.
+func Extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	values := md.Get(traceparentKey)
+	if len(values) == 0 {
+		return ctx
+	}
+	sc, err := ParseTraceparent(values[0])
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
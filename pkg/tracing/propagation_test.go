@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"surrounding spaces", " 00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"later version with more fields", "01-" + traceID + "-" + spanID + "-01-what-comes-next", true, true},
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"version not hex", "0x-" + traceID + "-" + spanID + "-01", false, false},
+		{"too few fields", "00-" + traceID + "-" + spanID, false, false},
+		{"short trace id", "00-" + traceID[:30] + "-" + spanID + "-01", false, false},
+		{"short span id", "00-" + traceID + "-" + spanID[:14] + "-01", false, false},
+		{"uppercase trace id", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("ParseTraceparent(%q) = %v, want an error", tt.value, sc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTraceparent(%q): %v", tt.value, err)
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != tt.sampled {
+				t.Errorf("ParseTraceparent(%q) = %v, want %s %s sampled %v", tt.value, sc, traceID, spanID, tt.sampled)
+			}
+		})
+	}
+}
+
+func TestFormatTraceparentRoundTrip(t *testing.T) {
+	_, span := NewTracer(NewInMemoryExporter()).Start(context.Background(), "root", KindInternal)
+	sc, err := ParseTraceparent(FormatTraceparent(span.SpanContext()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc != span.SpanContext() {
+		t.Errorf("round trip = %v, want %v", sc, span.SpanContext())
+	}
+}
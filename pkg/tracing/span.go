@@ -0,0 +1,154 @@
+// Package tracing is a small OpenTelemetry-style tracer for the training services: spans
+// for every RPC and stream message, W3C trace context carried in gRPC metadata, and
+// exporters that print spans or keep them in memory for tests.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+type SpanKind string
+
+const (
+	KindInternal SpanKind = "internal"
+	KindServer   SpanKind = "server"
+	KindClient   SpanKind = "client"
+)
+
+// Event is something that happened at a point in time during a span.
+type Event struct {
+	Name       string            `json:"name"`
+	Time       time.Time         `json:"time"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Span is one timed operation. It is exported when End is called.
+type Span struct {
+	Name         string            `json:"name"`
+	Kind         SpanKind          `json:"kind"`
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	StartTime    time.Time         `json:"start"`
+	EndTime      time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Events       []Event           `json:"events,omitempty"`
+	Status       string            `json:"status"`
+
+	tracer *Tracer
+	sc     SpanContext
+	mu     sync.Mutex
+	ended  bool
+}
+
+// SpanContext returns the identity of the span to propagate to its children.
+func (s *Span) SpanContext() SpanContext { return s.sc }
+
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) AddEvent(name string, attributes map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Events = append(s.Events, Event{Name: name, Time: time.Now(), Attributes: attributes})
+}
+
+// SetStatus records the outcome, a gRPC status code name by convention.
+func (s *Span) SetStatus(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Status = status
+}
+
+// End finishes the span and hands it to the exporter, once.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Tracer starts spans and sends the finished ones to its Exporter.
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the span the context is in, if any.
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	return span, ok
+}
+
+// ContextWithRemoteSpanContext makes sc, received from another process, the parent of
+// the next span started from the returned context.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Start begins a span as a child of the span in ctx, or of a remote parent, or as a new trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{Name: name, Kind: kind, StartTime: time.Now(), Status: "OK", tracer: t}
+
+	var parent SpanContext
+	if p, ok := SpanFromContext(ctx); ok {
+		parent = p.sc
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = remote
+	}
+	if parent.IsValid() {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.ParentSpanID = parent.SpanID.String()
+	} else {
+		rand.Read(span.sc.TraceID[:])
+		span.sc.Sampled = true
+	}
+	rand.Read(span.sc.SpanID[:])
+	span.TraceID = span.sc.TraceID.String()
+	span.SpanID = span.sc.SpanID.String()
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}